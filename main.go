@@ -1,15 +1,48 @@
 package main
 
-import "net-pinger/src"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net-pinger/src"
+	"os"
+	"os/signal"
+	"syscall"
+)
 
 func main() {
 	p, err := src.NewPingFromCommandLine()
 	if err != nil {
-		panic(err)
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// an interrupt stops the pinger gracefully, saving the state and running the pending commands
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		cancel(fmt.Errorf("signal %s", <-signals))
+	}()
+
+	err = p.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	if err != nil && !errors.Is(err, src.ErrNeverAlive) && !errors.Is(err, src.ErrStartupTimeout) {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if p.UseExitCode() {
+		if p.IsAlive() {
+			os.Exit(0)
+		}
+		os.Exit(1)
 	}
 
-	err = p.Run()
 	if err != nil {
-		panic(err)
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,269 @@
+package src
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config holds the options of a pinger embedded into another program, they
+// mirror the command line ones of the same names. Start from DefaultConfig,
+// the zero values of the other fields disable the features as on the command line.
+type Config struct {
+	Logger *zap.Logger // nil to discard the logs
+
+	Hosts       []string // in the command line form, <host>[=label][:alive=N,dead=N,weight=N,pause=D]
+	TargetsFile string   // file with the hosts, one per line
+	GroupsFile  string   // file with the additional groups
+	Strict      bool     // refuse the hosts listed more than once instead of warning
+
+	Wait              time.Duration // a single ping wait deadline
+	Pause             time.Duration // delay between pings
+	MinPause          time.Duration // delay between pings of the flapping hosts, 0 to disable
+	Jitter            float64       // random deviation of the pause as a share of it, e.g. 0.1
+	AliveCount        uint8         // number of alive pings to consider host alive
+	DeadCount         uint8         // number of dead pings to consider host dead
+	Window            int           // number of the last pings the counts are taken from, 0 for the consecutive ones
+	InitialState      string        // state of the hosts until they reach either count, down or unknown
+	RTTThreshold      time.Duration // rtt above which a host is slow, 0 to disable
+	DegradedCount     uint8         // number of slow replies to consider host degraded
+	Count             int           // number of rounds to run, 0 for infinite
+	StartupTimeout    time.Duration // time for the groups to become alive before Run fails, 0 to wait forever
+	RoundSummary      bool          // log a line per round as a heartbeat
+	ShowConfig        bool          // log the effective config at info instead of debug
+	GroupAlive        int           // total weight of alive hosts to consider whole setup alive, 0 for all
+	GroupDead         int           // total weight of alive hosts to consider whole setup dead
+	GroupMode         string        // any or all setting the group thresholds from the weight, count or empty for the given ones
+	GroupStableRounds int           // rounds a group stays past its threshold before transitioning
+
+	AliveCmd       []string      // commands to run in order when Alive
+	DeadCmd        []string      // commands to run in order when Dead
+	HostAliveCmd   string        // command to run when a single host is Alive
+	HostDeadCmd    string        // command to run when a single host is Dead
+	DegradedCmd    string        // command to run when a single host is Degraded
+	CmdTimeout     time.Duration // deadline after which a command is killed
+	CmdCooldown    time.Duration // minimal interval between the transition commands of a group
+	InitialCommand bool          // run the command of the initial group state once assessed
+	AliveWebhook   string        // URL to POST to when Alive
+	DeadWebhook    string        // URL to POST to when Dead
+
+	HeartbeatCmd      string        // command to run periodically while pinging, whatever the state
+	HeartbeatInterval time.Duration // interval of the heartbeat command
+
+	MetricsAddr    string // address to serve Prometheus metrics on
+	PushgatewayURL string // Prometheus pushgateway to push the metrics to on exit
+	StatusAddr     string // address to serve the JSON status on
+	InfluxURL      string // InfluxDB to write the round results to
+	InfluxBucket   string // bucket of the InfluxDB to write to
+	InfluxOrg      string // organization of the InfluxDB bucket
+	InfluxToken    string // API token of the InfluxDB
+	StatsdAddr     string // StatsD agent to emit the round results to
+	CSVFile        string // file to append the round results to as CSV
+	StateFile      string // file to keep the host and group state in across restarts
+	ControlSocket  string // unix socket to accept the control commands on
+	AllowEmpty     bool   // start without hosts, adding them at runtime
+	Events         bool   // write the transitions to stdout
+
+	ProbesPerRound   int           // number of requests sent to each host per round
+	SendRate         float64       // maximum number of requests sent per second, 0 for unlimited
+	SendConcurrency  int           // number of requests sent in parallel
+	PayloadSize      int           // size of the echo data
+	PayloadPattern   string        // byte in hex or inc to fill the echo data with, empty for zeros
+	RecvBuffer       int           // size of the receive buffer, 0 to fit the payload
+	TTL              int           // time to live of the outgoing packets, 0 for system default
+	TOS              int           // type of service of the outgoing packets
+	DSCP             int           // DSCP of the outgoing packets, an alternative to TOS
+	Interface        string        // interface to send the packets from
+	Source           net.IP        // address to send the packets from
+	RetryBind        int           // number of the retries of opening a socket before giving up
+	Raw              bool          // use raw ICMP sockets
+	PartialStack     bool          // skip the hosts of an address family whose socket fails to open
+	ECMPProbe        int           // number of the sockets per address family each host is pinged from in turn, 0 for one
+	ICMPID           int           // identifier of the echo requests, 0 for the process id
+	DontFragment     bool          // set the don't fragment bit
+	AllowBroadcast   bool          // allow a broadcast or multicast host per address family
+	MatchIDOnly      bool          // match the replies by the ICMP id and sequence number, whatever their source
+	TCPPort          int           // probe the hosts by connecting to the TCP port instead of ICMP, 0 to disable
+	HTTPURL          string        // probe the hosts by requesting the URL instead of ICMP
+	HTTPExpectStatus int           // expected HTTP status, 0 for any 2xx
+	ResolvePTR       bool          // look up the names of the numeric hosts for the logs
+	ResolveInterval  time.Duration // hostname re-resolution interval, 0 to disable
+
+	ResolveMinInterval time.Duration // minimal interval between the re-resolutions of a hostname
+}
+
+// DefaultConfig returns the config with the command line defaults
+func DefaultConfig() Config {
+	return Config{
+		Wait:              time.Second,
+		Pause:             5 * time.Second,
+		AliveCount:        3,
+		DeadCount:         3,
+		InitialState:      stateDown,
+		DegradedCount:     3,
+		CmdTimeout:        10 * time.Second,
+		HeartbeatInterval: time.Minute,
+		ProbesPerRound:    1,
+		SendConcurrency:   1,
+		PayloadSize:       56,
+	}
+}
+
+// redacted replaces the secrets in the logged config
+const redacted = "<redacted>"
+
+// redactOption returns the value of the option for the logs, the tokens and the
+// webhook urls replaced entirely, the credentials and the query of the other urls
+func redactOption(name, value string) string {
+	if value == "" {
+		return value
+	}
+	switch name {
+	case "influx-token", "alive-webhook", "dead-webhook":
+		return redacted
+	case "pushgateway-url", "influx-url":
+		return redactURL(value)
+	}
+	return value
+}
+
+// redactURL replaces the user info and the query of the url, either may carry a token
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+
+	user, query := u.User != nil, u.RawQuery != ""
+	u.User, u.RawQuery = nil, ""
+	s := u.String()
+	if user {
+		s = strings.Replace(s, "//", "//"+redacted+"@", 1)
+	}
+	if query {
+		s += "?" + redacted
+	}
+	return s
+}
+
+// configFields returns the options of the config for the logs
+func configFields(cfg Config) []zap.Field {
+	cfg.InfluxToken = redactOption("influx-token", cfg.InfluxToken)
+	cfg.AliveWebhook = redactOption("alive-webhook", cfg.AliveWebhook)
+	cfg.DeadWebhook = redactOption("dead-webhook", cfg.DeadWebhook)
+	cfg.PushgatewayURL = redactOption("pushgateway-url", cfg.PushgatewayURL)
+	cfg.InfluxURL = redactOption("influx-url", cfg.InfluxURL)
+
+	v := reflect.ValueOf(cfg)
+	fields := make([]zap.Field, 0, v.NumField())
+	for i := range v.NumField() {
+		if name := v.Type().Field(i).Name; name != "Logger" {
+			fields = append(fields, zap.Any(name, v.Field(i).Interface()))
+		}
+	}
+	return fields
+}
+
+// NewPing creates the pinger of the config and opens its sockets
+func NewPing(cfg Config) (*Ping, error) {
+	p := &Ping{
+		log:               cfg.Logger,
+		targetsFile:       cfg.TargetsFile,
+		groupsFile:        cfg.GroupsFile,
+		strict:            cfg.Strict,
+		waitTimeout:       cfg.Wait,
+		pauseDuration:     cfg.Pause,
+		minPause:          cfg.MinPause,
+		jitter:            percent(cfg.Jitter),
+		aliveCount:        cfg.AliveCount,
+		deadCount:         cfg.DeadCount,
+		window:            cfg.Window,
+		initialState:      cfg.InitialState,
+		rttThreshold:      cfg.RTTThreshold,
+		degradedCount:     cfg.DegradedCount,
+		count:             cfg.Count,
+		startupTimeout:    cfg.StartupTimeout,
+		roundSummary:      cfg.RoundSummary,
+		showConfig:        cfg.ShowConfig,
+		options:           configFields(cfg),
+		groupAlive:        cfg.GroupAlive,
+		groupDead:         cfg.GroupDead,
+		groupMode:         cfg.GroupMode,
+		groupStableRounds: cfg.GroupStableRounds,
+		cmdAlive:          cfg.AliveCmd,
+		cmdDead:           cfg.DeadCmd,
+		cmdHostAlive:      cfg.HostAliveCmd,
+		cmdHostDead:       cfg.HostDeadCmd,
+		cmdDegraded:       cfg.DegradedCmd,
+		cmdTimeout:        cfg.CmdTimeout,
+		cmdCooldown:       cfg.CmdCooldown,
+		initialCommand:    cfg.InitialCommand,
+		aliveWebhook:      cfg.AliveWebhook,
+		deadWebhook:       cfg.DeadWebhook,
+		cmdHeartbeat:      cfg.HeartbeatCmd,
+		heartbeatEvery:    cfg.HeartbeatInterval,
+		metricsAddr:       cfg.MetricsAddr,
+		pushgatewayURL:    cfg.PushgatewayURL,
+		statusAddr:        cfg.StatusAddr,
+		influxURL:         cfg.InfluxURL,
+		influxBucket:      cfg.InfluxBucket,
+		influxOrg:         cfg.InfluxOrg,
+		influxToken:       cfg.InfluxToken,
+		statsdAddr:        cfg.StatsdAddr,
+		csvFile:           cfg.CSVFile,
+		stateFile:         cfg.StateFile,
+		controlSocket:     cfg.ControlSocket,
+		allowEmpty:        cfg.AllowEmpty,
+		writeEvents:       cfg.Events,
+		probesPerRound:    cfg.ProbesPerRound,
+		sendRate:          cfg.SendRate,
+		sendConcurrency:   cfg.SendConcurrency,
+		payloadSize:       cfg.PayloadSize,
+		recvBuffer:        cfg.RecvBuffer,
+		ttl:               cfg.TTL,
+		tos:               cfg.TOS,
+		dscp:              cfg.DSCP,
+		iface:             cfg.Interface,
+		source:            cfg.Source,
+		retryBind:         cfg.RetryBind,
+		raw:               cfg.Raw,
+		partialStack:      cfg.PartialStack,
+		ecmpProbe:         cfg.ECMPProbe,
+		icmpID:            cfg.ICMPID,
+		dontFragment:      cfg.DontFragment,
+		allowBroadcast:    cfg.AllowBroadcast,
+		matchIDOnly:       cfg.MatchIDOnly,
+		tcpPort:           cfg.TCPPort,
+		httpURL:           cfg.HTTPURL,
+		httpExpect:        cfg.HTTPExpectStatus,
+		resolvePTR:        cfg.ResolvePTR,
+		resolveEvery:      cfg.ResolveInterval,
+		resolveMinEvery:   cfg.ResolveMinInterval,
+	}
+	if p.log == nil {
+		p.log = zap.NewNop()
+	}
+
+	if cfg.PayloadPattern != "" {
+		if err := p.payloadPattern.Set(cfg.PayloadPattern); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if p.targets, p.groups, err = p.loadTargets(nil, cfg.Hosts); err != nil {
+		return nil, err
+	}
+	if len(p.targets) == 0 && len(p.groups) == 0 && !p.emptyAllowed() {
+		return nil, errors.New("no hosts to ping")
+	}
+	if err = p.validate(); err != nil {
+		return nil, err
+	}
+
+	return p.open()
+}
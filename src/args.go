@@ -0,0 +1,474 @@
+package src
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+func (p *Ping) readArguments() logOptions {
+	var logOpts logOptions
+	generalOptions, pingOptions, groupOptions := p.flagSets(&logOpts)
+	pflag.CommandLine.AddFlagSet(generalOptions)
+	pflag.CommandLine.AddFlagSet(pingOptions)
+	pflag.CommandLine.AddFlagSet(groupOptions)
+
+	pflag.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "USAGE: %s [options] <host>[=label][:alive=N,dead=N,weight=N,pause=D] ...\n", os.Args[0])
+
+		_, _ = fmt.Fprint(os.Stderr, "\nGeneral options:\n")
+		generalOptions.PrintDefaults()
+
+		_, _ = fmt.Fprint(os.Stderr, "\nPing options:\n")
+		pingOptions.PrintDefaults()
+
+		_, _ = fmt.Fprint(os.Stderr, "\nGrouping options:\n")
+		groupOptions.PrintDefaults()
+	}
+
+	pflag.Parse()
+	pflag.VisitAll(func(f *pflag.Flag) {
+		p.options = append(p.options, zap.String(f.Name, redactOption(f.Name, f.Value.String())))
+	})
+
+	var err error
+	if p.targets, p.groups, err = p.loadTargets(pflag.CommandLine, pflag.Args()); err != nil {
+		exitUsage(err)
+	}
+
+	if len(p.targets) == 0 && len(p.groups) == 0 && !p.emptyAllowed() {
+		exitUsage(errors.New("no hosts to ping"))
+	}
+
+	if err = p.validate(); err != nil {
+		exitUsage(err)
+	}
+
+	if logOpts.format != "console" && logOpts.format != "json" {
+		exitUsage(fmt.Errorf("unknown log format %s", logOpts.format))
+	}
+	if logOpts.color != colorAuto && logOpts.color != colorAlways && logOpts.color != colorNever {
+		exitUsage(fmt.Errorf("color must be %s, %s or %s", colorAuto, colorAlways, colorNever))
+	}
+	if logOpts.verbose {
+		if generalOptions.Changed("log-level") {
+			exitUsage(errors.New("only one of verbose and log level may be given"))
+		}
+		logOpts.level = "debug"
+	}
+	if _, err := parseLogLevel(logOpts.level); err != nil {
+		exitUsage(err)
+	}
+	if logOpts.syslog && logOpts.file != "" {
+		exitUsage(errors.New("only one of syslog and log file may be given"))
+	}
+	if logOpts.maxSize <= 0 || logOpts.maxBackups < 0 {
+		exitUsage(errors.New("log max size must be positive and max backups not negative"))
+	}
+
+	return logOpts
+}
+
+// flagSets binds the options of the pinger and of the log to the flags,
+// grouped for the usage
+func (p *Ping) flagSets(logOpts *logOptions) (generalOptions, pingOptions, groupOptions *pflag.FlagSet) {
+	defaults := DefaultConfig()
+
+	generalOptions = pflag.NewFlagSet("General", pflag.ExitOnError)
+	generalOptions.SortFlags = false
+	generalOptions.BoolVarP(&logOpts.verbose, "verbose", "v", false, "Enable verbose logging, same as --log-level debug")
+	generalOptions.StringVar(&logOpts.level, "log-level", "info", "Log level, debug, info, warn or error")
+	generalOptions.BoolVar(&p.showConfig, "show-config", false, "Log the effective options, groups and hosts at the start at info instead of debug, e.g. for a bug report")
+	generalOptions.StringVar(&logOpts.format, "log-format", "console", "Log format, console or json")
+	generalOptions.BoolVar(&logOpts.syslog, "syslog", false, "Log to syslog instead of stderr")
+	generalOptions.StringVar(&logOpts.syslogAddr, "syslog-addr", "", "Remote syslog address, e.g. udp://logs:514 (default local syslog)")
+	generalOptions.StringVar(&logOpts.file, "log-file", "", "Log to the file instead of stderr, rotating it by size")
+	generalOptions.IntVar(&logOpts.maxSize, "log-max-size", 100, "Size in megabytes of the log file to rotate it at")
+	generalOptions.IntVar(&logOpts.maxBackups, "log-max-backups", 3, "Number of the rotated log files to keep (0 for all)")
+	generalOptions.StringVar(&logOpts.color, "color", colorAuto, "Colorize the console log on stderr, green for alive and red for dead, auto (if a terminal), always or never")
+	generalOptions.StringArrayVarP(&p.cmdAlive, "alive-cmd", "a", nil, "Command to run when network is alive, {{.IP}}, {{.Group}}, {{.State}} and {{.UpCount}} are replaced with the event, repeat to run several in order")
+	generalOptions.StringArrayVarP(&p.cmdDead, "dead-cmd", "d", nil, "Command to run when network is dead, accepts the same placeholders, repeat to run several in order")
+	generalOptions.StringVar(&p.cmdHostAlive, "host-alive-cmd", "", "Command to run when a single host is alive, {{.IP}} and the other placeholders of alive-cmd are replaced with the event")
+	generalOptions.StringVar(&p.cmdHostDead, "host-dead-cmd", "", "Command to run when a single host is dead, {{.IP}} and the other placeholders of alive-cmd are replaced with the event")
+	generalOptions.StringVar(&p.cmdDegraded, "degraded-cmd", "", "Command to run when a single host is degraded, {{.IP}} and the other placeholders of alive-cmd are replaced with the event")
+	generalOptions.StringVar(&p.aliveWebhook, "alive-webhook", "", "URL to POST the event to when network is alive")
+	generalOptions.StringVar(&p.deadWebhook, "dead-webhook", "", "URL to POST the event to when network is dead")
+	generalOptions.StringVar(&p.cmdHeartbeat, "heartbeat-cmd", "", "Command to run periodically while pinging, whatever the state, for an external watchdog to see the pinger is working")
+	generalOptions.DurationVar(&p.heartbeatEvery, "heartbeat-interval", defaults.HeartbeatInterval, "Interval of the heartbeat command, run between the rounds, so at most once a round")
+	generalOptions.DurationVar(&p.cmdTimeout, "cmd-timeout", defaults.CmdTimeout, "Time after which a running command is killed")
+	generalOptions.DurationVar(&p.cmdCooldown, "command-cooldown", 0, "Suppress the network alive/dead commands fired within this time of the previous one")
+	generalOptions.BoolVar(&p.initialCommand, "initial-command", false, "Run the alive or dead command of the initial network state once every host was pinged enough times to reach its count, so a start into the dead state is reported")
+	generalOptions.BoolVar(&p.exitCode, "exit-code", false, "Exit with 0 if the network is alive at the end and 1 otherwise")
+	generalOptions.BoolVar(&p.roundSummary, "round-summary", false, "Log a line per round with the number of the alive hosts and the group states, as a heartbeat")
+	generalOptions.StringArrayVar(&p.targetLists, "targets", nil, "Comma-separated hosts to ping in addition to the arguments, e.g. 192.0.2.1,192.0.2.0/28,db.internal")
+	generalOptions.StringVar(&p.targetsFile, "targets-file", "", "File with the hosts to ping, one per line")
+	generalOptions.StringVar(&p.groupsFile, "groups-file", "", "File with additional groups of hosts with their own thresholds and commands")
+	generalOptions.BoolVar(&p.strict, "strict", false, "Refuse the hosts listed more than once instead of warning about them")
+	generalOptions.StringVar(&p.configFile, "config", "", "YAML config file, the command line options override its values, reloaded on SIGHUP")
+	generalOptions.StringVar(&p.stateFile, "state-file", "", "File to save the host and group state to on exit and restore it from on start")
+	generalOptions.StringVar(&p.controlSocket, "control-socket", "", "Unix socket accepting the status, add, remove, pause and resume commands, e.g. /run/pinger.sock")
+	generalOptions.BoolVar(&p.allowEmpty, "allow-empty", false, "Start without hosts to add them at runtime, implied by --control-socket")
+	generalOptions.BoolVar(&p.writeEvents, "events", false, "Write the host and group state transitions to stdout as JSON lines, the commands output goes to stderr then")
+	generalOptions.StringVar(&p.metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on, e.g. :9107")
+	generalOptions.StringVar(&p.pushgatewayURL, "pushgateway-url", "", "Prometheus pushgateway URL to push the metrics to on exit, for the runs too short to be scraped, e.g. http://localhost:9091")
+	generalOptions.StringVar(&p.statusAddr, "status-addr", "", "Address to serve the JSON status on, e.g. :8080")
+	generalOptions.StringVar(&p.influxURL, "influx-url", "", "InfluxDB URL to write the round results to, e.g. http://localhost:8086")
+	generalOptions.StringVar(&p.influxBucket, "influx-bucket", "", "InfluxDB bucket (database for InfluxDB 1.8) to write to")
+	generalOptions.StringVar(&p.influxOrg, "influx-org", "", "InfluxDB organization of the bucket")
+	generalOptions.StringVar(&p.influxToken, "influx-token", "", "InfluxDB API token")
+	generalOptions.StringVar(&p.statsdAddr, "statsd-addr", "", "StatsD agent address to emit the round results to over UDP, e.g. localhost:8125")
+	generalOptions.StringVar(&p.csvFile, "csv-file", "", "File to append a row per host per round to as CSV: timestamp, ip, label, group, success, rtt_ms")
+
+	pingOptions = pflag.NewFlagSet("Ping", pflag.ExitOnError)
+	pingOptions.SortFlags = false
+	pingOptions.DurationVar(&p.waitTimeout, "wait", defaults.Wait, "Single ping wait timeout")
+	pingOptions.DurationVar(&p.pauseDuration, "pause", defaults.Pause, "Between ping pause duration")
+	pingOptions.Var(&p.jitter, "jitter", "Randomly deviate the pause by up to this share of it, e.g. 10%")
+	pingOptions.DurationVar(&p.minPause, "min-pause", 0, "Enable the adaptive mode pinging the flapping hosts with this pause (default 0, disabled)")
+	pingOptions.Uint8Var(&p.aliveCount, "alive-count", defaults.AliveCount, "Number of alive pings to consider host alive")
+	pingOptions.Uint8Var(&p.deadCount, "dead-count", defaults.DeadCount, "Number of alive pings to consider host dead")
+	pingOptions.IntVar(&p.window, "window", 0, "Take the alive and dead counts from this number of the last pings, e.g. alive on 3 replies of the last 5, instead of the consecutive ones (default 0, consecutive)")
+	pingOptions.StringVar(&p.initialState, "initial-state", defaults.InitialState, "State of the hosts until they reach either count, down or unknown, the unknown hosts keep their group from going dead")
+	pingOptions.DurationVar(&p.rttThreshold, "rtt-threshold", 0, "Round-trip time above which the replies of an alive host are slow (default 0, disabled)")
+	pingOptions.Uint8Var(&p.degradedCount, "degraded-count", defaults.DegradedCount, "Number of slow replies to consider host degraded, and of the fast ones to consider it recovered")
+	pingOptions.IntVarP(&p.count, "count", "c", 0, "Number of rounds to run before exiting (default 0, infinite)")
+	pingOptions.DurationVar(&p.startupTimeout, "startup-timeout", 0, "Exit with an error if the network has not become alive within this time from start, checked every round (default 0, wait forever)")
+	pingOptions.IntVar(&p.probesPerRound, "probes-per-round", defaults.ProbesPerRound, "Number of echo requests sent to each host per round, a reply to any of them counts")
+	pingOptions.Float64Var(&p.sendRate, "send-rate", 0, "Maximum number of echo requests sent per second (default 0, unlimited)")
+	pingOptions.IntVar(&p.sendConcurrency, "send-concurrency", defaults.SendConcurrency, "Number of echo requests sent in parallel")
+	pingOptions.IntVar(&p.payloadSize, "payload-size", defaults.PayloadSize, "Size of the echo data in bytes")
+	pingOptions.IntVar(&p.recvBuffer, "recv-buffer", 0, "Size of the receive buffer in bytes, e.g. 9000 for the jumbo frames (default 0, fitting the payload and at least 1500)")
+	pingOptions.Var(&p.payloadPattern, "payload-pattern", "Byte in hex, e.g. 0x55, or inc for the counting bytes to fill the echo data with, the replies carrying back other data are reported as corrupted")
+	pingOptions.IntVar(&p.ttl, "ttl", 0, "Time to live (hop limit) of the outgoing packets (default 0, system default)")
+	pingOptions.BoolVar(&p.dontFragment, "dont-fragment", false, "Set the don't fragment bit to detect the MTU black holes with a large payload")
+	pingOptions.BoolVar(&p.allowBroadcast, "allow-broadcast", false, "Allow pinging a broadcast or a multicast address, one per address family, any reply to it counts")
+	pingOptions.BoolVar(&p.matchIDOnly, "match-id-only", false, "Match the replies by the ICMP id and sequence number only, accepting them from any address, e.g. behind a NAT")
+	pingOptions.IntVar(&p.tos, "tos", 0, "Type of service (traffic class) byte of the outgoing packets")
+	pingOptions.IntVar(&p.dscp, "dscp", 0, "DSCP of the outgoing packets, an alternative to --tos")
+	pingOptions.IntVar(&p.tcpPort, "tcp-port", 0, "Probe the hosts by connecting to this TCP port instead of ICMP echo (default 0, disabled)")
+	pingOptions.StringVar(&p.httpURL, "http-url", "", "Probe the hosts by requesting this URL instead of ICMP echo, {ip} is replaced with the host address, otherwise the host is connected to in place of the URL one")
+	pingOptions.IntVar(&p.httpExpect, "http-expect-status", 0, "HTTP status of the alive hosts (default 0, any 2xx)")
+	pingOptions.BoolVar(&p.raw, "raw", false, "Use raw ICMP sockets, requires root (default unprivileged ones, falling back to raw if not permitted)")
+	pingOptions.BoolVar(&p.partialStack, "partial-stack", false, "Skip the hosts of an address family whose socket fails to open, e.g. without IPv6 on the host, pinging the others instead of exiting")
+	pingOptions.IntVar(&p.ecmpProbe, "ecmp-probe", 0, "Ping each host from this number of sockets in turn, each with its own ICMP identifier, which the routers hashing by it spread over the ECMP paths, to detect a single failing path (default 0, one socket)")
+	pingOptions.IntVar(&p.icmpID, "icmp-id", 0, "Identifier of the echo requests to tell apart the replies of several instances with the raw sockets (default 0, process id), the unprivileged sockets on linux always get a unique one from the kernel")
+	pingOptions.StringVarP(&p.iface, "interface", "I", "", "Interface to send the packets from")
+	pingOptions.IPVarP(&p.source, "source", "S", nil, "Address to send the packets from")
+	pingOptions.IntVar(&p.retryBind, "retry-bind", 0, "Retry opening a socket this many times, e.g. at boot before the network is ready, the delay doubling from 500ms to 30s (default 0, exit at once)")
+	pingOptions.BoolVar(&p.resolvePTR, "resolve-ptr", false, "Look up the names of the hosts given by address in the background to show them in the logs")
+	pingOptions.DurationVar(&p.resolveEvery, "resolve-interval", 0, "Hostname re-resolution interval, a host going dead is re-resolved early (default 0, disabled)")
+	pingOptions.DurationVar(&p.resolveMinEvery, "resolve-min-interval", 0, "Minimal interval between the re-resolutions of a hostname, the record TTL is not known to the resolver (default 0, unlimited)")
+
+	groupOptions = pflag.NewFlagSet("Group", pflag.ExitOnError)
+	groupOptions.SortFlags = false
+	groupOptions.IntVar(&p.groupAlive, "group-alive", 0, "total weight of alive hosts to consider whole setup alive, each host weighs 1 unless given weight=N (default ip count)")
+	groupOptions.IntVar(&p.groupDead, "group-dead", 0, "total weight of alive hosts to consider whole setup dead (default 0)")
+	groupOptions.StringVar(&p.groupMode, "group-mode", groupModeCount, "any for the setup alive with any host alive and dead with all dead, all for alive with all alive and dead with any dead, count for the group-alive and group-dead thresholds")
+	groupOptions.IntVar(&p.groupStableRounds, "group-stable-rounds", 0, "number of consecutive rounds past the threshold before the setup changes state (default 0, immediately)")
+
+	return generalOptions, pingOptions, groupOptions
+}
+
+// exitUsage reports the invalid argument and exits showing the usage
+func exitUsage(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	pflag.Usage()
+	os.Exit(2)
+}
+
+// loadTargets reads the targets of the command line group and the additional
+// groups from the config file, the arguments, the targets and the groups files
+func (p *Ping) loadTargets(flags *pflag.FlagSet, args []string) ([]target, []*group, error) {
+	var targets []target
+	var groups []*group
+
+	if p.configFile != "" {
+		t, g, err := readConfigFile(p.configFile, flags)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, t...)
+		groups = append(groups, g...)
+	}
+
+	for _, list := range p.targetLists {
+		args = append(slices.Clip(args), splitTargetList(list)...)
+	}
+	for _, arg := range args {
+		t, err := parseTarget(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, t...)
+	}
+
+	if p.targetsFile != "" {
+		t, err := readTargetsFile(p.targetsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, t...)
+	}
+
+	if p.groupsFile != "" {
+		g, err := readGroupsFile(p.groupsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, g...)
+	}
+
+	if err := checkGroupOverlap(targets, groups); err != nil {
+		return nil, nil, err
+	}
+	if duplicates := duplicateHosts(targets, groups); p.strict && len(duplicates) > 0 {
+		return nil, nil, fmt.Errorf("hosts listed more than once: %s", strings.Join(duplicates, ", "))
+	}
+
+	all := targets
+	for _, g := range groups {
+		all = append(all, g.targets...)
+	}
+	if err := p.checkBroadcasts(all); err != nil {
+		return nil, nil, err
+	}
+	if err := p.checkHostOptions(all); err != nil {
+		return nil, nil, err
+	}
+
+	for _, g := range groups {
+		for _, command := range slices.Concat(g.cmdAlive, g.cmdDead) {
+			if err := checkCommand(command); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", g.describe(), err)
+			}
+		}
+	}
+
+	return targets, groups, nil
+}
+
+// checkBroadcasts rejects the broadcast and multicast hosts unless allowed, and more
+// than one of them in an address family, as the replies to them cannot be told apart
+func (p *Ping) checkBroadcasts(targets []target) error {
+	local := localBroadcasts()
+	seen := make(map[bool]net.IP) // by whether IPv4
+	for _, t := range targets {
+		if !isBroadcast(t.ip, local) {
+			continue
+		}
+		if !p.allowBroadcast {
+			return fmt.Errorf("%s is a broadcast or multicast address, which never replies itself, pass --allow-broadcast to ping it", t.ip)
+		}
+
+		v4 := t.ip.To4() != nil
+		if other, ok := seen[v4]; ok && !other.Equal(t.ip) {
+			return fmt.Errorf("only one broadcast or multicast host of an address family may be pinged, the replies to %s and %s cannot be told apart", other, t.ip)
+		}
+		seen[v4] = t.ip
+	}
+	return nil
+}
+
+// checkHostOptions rejects the host pauses not longer than the wait and the counts
+// not fitting the window, the same as the global ones
+func (p *Ping) checkHostOptions(targets []target) error {
+	for _, t := range targets {
+		if t.opts.pause > 0 && t.opts.pause <= p.waitTimeout {
+			return fmt.Errorf("pause (%s) of %s must be longer than wait (%s)", t.opts.pause, t.address(), p.waitTimeout)
+		}
+		if t.opts.aliveCount == 0 && t.opts.deadCount == 0 {
+			continue
+		}
+		alive, dead := cmp.Or(t.opts.aliveCount, p.aliveCount), cmp.Or(t.opts.deadCount, p.deadCount)
+		if err := checkWindow(alive, dead, p.window); err != nil {
+			return fmt.Errorf("%s: %w", t.address(), err)
+		}
+	}
+	return nil
+}
+
+// checkWindow rejects the counts beyond the window, and the ones both reached in a
+// single window, with which the host would flip every round on a steady loss
+func checkWindow(alive, dead uint8, window int) error {
+	if window == 0 {
+		return nil
+	}
+	if max(int(alive), int(dead)) > window {
+		return fmt.Errorf("alive and dead counts must fit the window (%d)", window)
+	}
+	if int(alive)+int(dead) <= window {
+		return fmt.Errorf("alive and dead counts must add up to more than the window (%d)", window)
+	}
+	return nil
+}
+
+const (
+	maxProbesPerRound = 16   // keeps the sequence numbers of a round a small part of their space
+	maxWindow         = 1000 // limits the memory of the results kept per host
+	maxECMPSockets    = 64   // limits the open sockets per address family
+)
+
+// validate checks the option values
+func (p *Ping) validate() error {
+	if p.payloadSize < timestampSize || p.payloadSize > maxPayloadSize {
+		return fmt.Errorf("payload size must be between %d and %d bytes", timestampSize, maxPayloadSize)
+	}
+
+	if minBuffer := maxIPHeaderSize + icmpHeaderSize + p.payloadSize; p.recvBuffer != 0 && p.recvBuffer < minBuffer {
+		return fmt.Errorf("recv buffer must fit the reply of %d bytes, or be 0 to fit it automatically", minBuffer)
+	}
+
+	if p.waitTimeout <= 0 || p.pauseDuration <= 0 {
+		return errors.New("wait and pause must be positive")
+	}
+	// a reply arriving after the wait would be taken for a lost one of the next round
+	if p.waitTimeout >= p.pauseDuration {
+		return fmt.Errorf("wait (%s) must be shorter than pause (%s)", p.waitTimeout, p.pauseDuration)
+	}
+
+	if p.rttThreshold < 0 || p.rttThreshold >= p.waitTimeout {
+		return fmt.Errorf("rtt threshold must be shorter than wait (%s), or 0 to disable", p.waitTimeout)
+	}
+	if p.initialState != stateDown && p.initialState != stateUnknown {
+		return fmt.Errorf("initial state must be %s or %s", stateDown, stateUnknown)
+	}
+
+	if p.window < 0 || p.window > maxWindow {
+		return fmt.Errorf("window must be between 0 and %d", maxWindow)
+	}
+	if err := checkWindow(p.aliveCount, p.deadCount, p.window); err != nil {
+		return err
+	}
+
+	if p.degradedCount == 0 {
+		return errors.New("degraded count must be at least 1")
+	}
+
+	if p.jitter >= 1 {
+		return errors.New("jitter must be below 100%")
+	}
+
+	if p.minPause < 0 || p.minPause > p.pauseDuration {
+		return errors.New("min pause must be between 0 and pause")
+	}
+	if p.minPause > 0 && p.waitTimeout >= p.minPause {
+		return fmt.Errorf("wait (%s) must be shorter than min pause (%s)", p.waitTimeout, p.minPause)
+	}
+
+	if p.tcpPort < 0 || p.tcpPort > 65535 {
+		return errors.New("tcp port must be between 1 and 65535, or 0 to disable")
+	}
+
+	if p.tcpPort > 0 && p.httpURL != "" {
+		return errors.New("only one of tcp port and http url may be given")
+	}
+
+	if p.retryBind < 0 {
+		return errors.New("retry bind must not be negative")
+	}
+
+	if p.ecmpProbe < 0 || p.ecmpProbe > maxECMPSockets {
+		return fmt.Errorf("ecmp probe must be between 0 and %d sockets", maxECMPSockets)
+	}
+	if p.ecmpProbe > 1 && p.probeMode() {
+		return errors.New("ecmp probe applies to ICMP, the TCP and HTTP probes vary the source port anyway")
+	}
+
+	if p.probesPerRound < 1 || p.probesPerRound > maxProbesPerRound {
+		return fmt.Errorf("probes per round must be between 1 and %d", maxProbesPerRound)
+	}
+
+	if p.sendRate < 0 {
+		return errors.New("send rate must not be negative")
+	}
+
+	if p.sendConcurrency < 1 {
+		return errors.New("send concurrency must be at least 1")
+	}
+
+	if p.ttl < 0 || p.ttl > 255 {
+		return errors.New("ttl must be between 1 and 255, or 0 for the system default")
+	}
+
+	if p.icmpID < 0 || p.icmpID > 65535 {
+		return errors.New("icmp id must be between 1 and 65535, or 0 for the process id")
+	}
+
+	if p.tos < 0 || p.tos > 255 {
+		return errors.New("tos must be between 0 and 255")
+	}
+	if p.dscp < 0 || p.dscp > 63 {
+		return errors.New("dscp must be between 0 and 63")
+	}
+	if p.dscp > 0 && p.tos > 0 {
+		return errors.New("only one of tos and dscp may be given")
+	}
+
+	if p.startupTimeout < 0 {
+		return errors.New("startup timeout must not be negative")
+	}
+
+	if p.groupAlive < 0 || p.groupDead < 0 {
+		return errors.New("group alive and dead must not be negative")
+	}
+	if err := checkGroupMode(p.groupMode, p.groupAlive, p.groupDead); err != nil {
+		return err
+	}
+	if p.groupStableRounds < 0 {
+		return errors.New("group stable rounds must not be negative")
+	}
+
+	if p.cmdHeartbeat != "" && p.heartbeatEvery <= 0 {
+		return errors.New("heartbeat interval must be positive")
+	}
+
+	for _, command := range slices.Concat(p.cmdAlive, p.cmdDead, []string{p.cmdHostAlive, p.cmdHostDead, p.cmdDegraded, p.cmdHeartbeat}) {
+		if err := checkCommand(command); err != nil {
+			return err
+		}
+	}
+
+	for _, webhook := range []string{p.aliveWebhook, p.deadWebhook} {
+		if webhook == "" {
+			continue
+		}
+		if err := checkWebhook(webhook); err != nil {
+			return err
+		}
+	}
+
+	if p.pushgatewayURL != "" {
+		if u, err := url.Parse(p.pushgatewayURL); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("pushgateway url %s must be http or https", p.pushgatewayURL)
+		}
+	}
+
+	if p.influxURL != "" {
+		if _, err := influxWriteURL(p.influxURL, p.influxBucket, p.influxOrg); err != nil {
+			return err
+		}
+	}
+
+	if p.statsdAddr != "" {
+		if _, err := net.ResolveUDPAddr("udp", p.statsdAddr); err != nil {
+			return fmt.Errorf("invalid statsd address: %w", err)
+		}
+	}
+
+	if p.resolveEvery < 0 || p.resolveMinEvery < 0 {
+		return errors.New("resolve interval and min interval must not be negative")
+	}
+
+	if p.source != nil && p.iface != "" {
+		return errors.New("only one of interface and source may be given")
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// commandQueueSize is the number of commands waiting for the running one before new ones are dropped
+const commandQueueSize = 64
+
+const (
+	stateAlive = "alive"
+	stateDead  = "dead"
+
+	stateDegraded = "degraded" // the state of the hosts alive but too slow
+	stateUnknown  = "unknown"  // the initial state of the hosts not assumed down
+	stateDown     = "down"     // the default initial state of the hosts, not reported
+
+	stateHeartbeat = "heartbeat" // the state of the event of the heartbeat command, not of a host
+)
+
+// event describes a state transition to the commands run on it
+type event struct {
+	IP      string    `json:"ip"`              // the host which caused the transition
+	Group   string    `json:"group,omitempty"` // the group of the host
+	State   string    `json:"state"`           // the new state
+	UpCount int       `json:"up_count"`        // number of hosts currently up
+	Time    time.Time `json:"timestamp"`       // time of the transition
+}
+
+func (p *Ping) newEvent(g *group, ip, state string) event {
+	return event{
+		IP:      ip,
+		Group:   g.name,
+		State:   state,
+		UpCount: g.totalAlive,
+		Time:    time.Now(),
+	}
+}
+
+// environ returns the environment variables exporting the event
+func (e event) environ() []string {
+	return []string{
+		"PINGER_IP=" + e.IP,
+		"PINGER_GROUP=" + e.Group,
+		"PINGER_STATE=" + e.State,
+		"PINGER_ALIVE_COUNT=" + strconv.Itoa(e.UpCount),
+	}
+}
+
+// queuedCommand is a rendered command waiting to be run
+type queuedCommand struct {
+	command string
+	state   string   // the state the command is run on
+	env     []string // the event variables
+	timeout time.Duration
+}
+
+// runCommand queues the command to be run in the background, so that neither
+// a slow nor a hung command can stall pinging. The commands run one at a time
+// in the order of the transitions, a full queue drops the new ones.
+func (p *Ping) runCommand(command string, e event) {
+	command, err := renderCommand(command, e)
+	if err != nil {
+		p.log.Error("Failed to render command", zap.String("command", command), zap.Error(err))
+		return
+	}
+
+	p.commands.Add(1)
+	select {
+	case p.cmdQueue <- queuedCommand{command: command, state: e.State, env: e.environ(), timeout: p.cmdTimeout}:
+	default:
+		p.commands.Done()
+		p.log.Error("Command queue is full, dropping the command",
+			zap.String("command", command),
+			zap.Int("queued", commandQueueSize))
+	}
+}
+
+// commandLoop runs the queued commands, killing each after its timeout
+func (p *Ping) commandLoop() {
+	for c := range p.cmdQueue {
+		p.execCommand(c)
+		p.commands.Done()
+	}
+}
+
+func (p *Ping) execCommand(c queuedCommand) {
+	p.log.Debug("Running command", zap.String("command", c.command))
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, c.command)
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdout = os.Stdout
+	if p.events != nil {
+		// keep the event stream clean
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	p.metrics.commandRun(c.state, err)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		p.log.Error("Command timed out", zap.String("command", c.command), zap.Duration("timeout", c.timeout))
+	} else if err != nil {
+		p.log.Error("Command failed", zap.String("command", c.command), zap.Error(err))
+	}
+}
+
+// renderCommand fills the {{.IP}}, {{.Group}}, {{.State}}, {{.UpCount}} and {{.Time}}
+// placeholders of the command with the event, the commands without them are run as is
+func renderCommand(command string, e event) (string, error) {
+	if !strings.Contains(command, "{{") {
+		return command, nil
+	}
+
+	t, err := template.New("command").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return command, err
+	}
+
+	var b strings.Builder
+	if err = t.Execute(&b, e); err != nil {
+		return command, err
+	}
+	return b.String(), nil
+}
+
+// checkCommand rejects the command templates which fail to render
+func checkCommand(command string) error {
+	if _, err := renderCommand(command, event{}); err != nil {
+		return fmt.Errorf("invalid command %q: %w", command, err)
+	}
+	return nil
+}
+
+// runHostCommand runs the per-host transition command, if any
+func (p *Ping) runHostCommand(command string, ri *remoteInfo, state string) {
+	if command == "" {
+		return
+	}
+
+	p.runCommand(command, p.newEvent(ri.group, ri.address(), state))
+}
+
+// heartbeat runs the heartbeat command once its interval has passed. It is run from
+// the main loop between the rounds, so a stuck loop stops the heartbeats as well.
+func (p *Ping) heartbeat() {
+	if p.cmdHeartbeat == "" || time.Since(p.lastBeat) < p.heartbeatEvery {
+		return
+	}
+	p.lastBeat = time.Now()
+	p.runCommand(p.cmdHeartbeat, event{State: stateHeartbeat, Time: p.lastBeat})
+}
+
+// shellCommand returns the command run by the system shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
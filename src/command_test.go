@@ -0,0 +1,172 @@
+package src
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRenderCommand(t *testing.T) {
+	e := event{IP: "192.0.2.1", Group: "uplinks", State: stateDead, UpCount: 1}
+
+	tests := []struct {
+		command string
+		want    string
+		wantErr bool
+	}{
+		{command: "birdc disable provider1", want: "birdc disable provider1"},
+		{command: `notify-send "{{.IP}} is {{.State}}"`, want: `notify-send "192.0.2.1 is dead"`},
+		{command: "echo {{.Group}} {{.UpCount}}", want: "echo uplinks 1"},
+		{command: "echo {ip}", want: "echo {ip}"},
+		{command: "echo {{.IP", wantErr: true},
+		{command: "echo {{.Host}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, err := renderCommand(tt.command, e)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, got %q", got)
+				}
+				if checkCommand(tt.command) == nil {
+					t.Error("checkCommand accepted the command")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandQueue(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command uses the posix shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "out")
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	for _, state := range []string{stateAlive, stateDead, stateAlive} {
+		p.runCommand("echo {{.State}} >> "+path, event{State: state})
+	}
+	p.commands.Wait()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "alive\ndead\nalive\n"; string(got) != want {
+		t.Errorf("got %q, want the commands run in order %q", got, want)
+	}
+}
+
+func TestCommandQueueOverflow(t *testing.T) {
+	// nothing takes the commands off the queue without room
+	p := &Ping{log: zap.NewNop(), cmdQueue: make(chan queuedCommand)}
+	p.runCommand("true", event{})
+	p.commands.Wait()
+}
+
+func TestHeartbeat(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command uses the posix shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "out")
+	p, _ := newTestPing(t, func(p *Ping) {
+		p.cmdHeartbeat = "echo beat >> " + path
+		p.heartbeatEvery = time.Hour
+	}, "192.0.2.1")
+	for range 3 {
+		p.heartbeat()
+	}
+	p.lastBeat = p.lastBeat.Add(-time.Hour)
+	p.heartbeat()
+	p.commands.Wait()
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "beat\nbeat\n" {
+		t.Errorf("got %q, want a beat at once and another after the interval", got)
+	}
+}
+
+func TestSeveralCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command uses the posix shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "out")
+	p, conn := newTestPing(t, func(p *Ping) {
+		p.cmdAlive = []string{"echo page >> " + path, "echo failover {{.State}} >> " + path}
+		p.cmdDead = []string{"echo dead >> " + path}
+	}, "192.0.2.1")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++"})
+	p.commands.Wait()
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "page\nfailover alive\n"; string(out) != want {
+		t.Errorf("got %q, want the alive commands run in order %q", out, want)
+	}
+}
+
+func TestHostCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command uses the posix shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "out")
+	p, conn := newTestPing(t, func(p *Ping) {
+		p.cmdHostAlive = "echo {{.IP}} {{.State}} >> " + path
+	}, "192.0.2.1")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++"})
+	p.commands.Wait()
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "192.0.2.1 alive\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestCommandMetric(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command uses the posix shell")
+	}
+
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	p.metrics = newMetrics(zap.NewNop(), "", "")
+	p.runCommand("true", event{State: stateAlive})
+	p.runCommand("exit 1", event{State: stateDead})
+	p.runCommand("exit 1", event{State: stateDead})
+	p.commands.Wait()
+
+	for _, tt := range []struct {
+		state, result string
+		want          float64
+	}{
+		{stateAlive, "success", 1},
+		{stateAlive, "failure", 0},
+		{stateDead, "failure", 2},
+	} {
+		if got := testutil.ToFloat64(p.metrics.commands.WithLabelValues(tt.state, tt.result)); got != tt.want {
+			t.Errorf("%s %s: got %v commands, want %v", tt.state, tt.result, got, tt.want)
+		}
+	}
+}
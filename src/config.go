@@ -0,0 +1,140 @@
+package src
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+)
+
+// readConfigFile loads a YAML config file, an alternative to the command line.
+// Its keys are the long flag names, the values apply only to the flags which
+// were not given on the command line. Additionally it accepts the list of
+// targets and the groups, having the options of the groups file plus the
+// name and the list of hosts:
+//
+//	wait: 1s
+//	alive-cmd: birdc enable provider1
+//	targets:
+//	  - 192.0.2.1
+//	  - 192.0.2.0/28:dead=5
+//	groups:
+//	  - name: cluster
+//	    group-alive: 2
+//	    hosts: [192.0.2.100, 192.0.2.101]
+func readConfigFile(path string, flags *pflag.FlagSet) ([]target, []*group, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cfg map[string]any
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// apply in a stable order so the errors are reproducible
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var targets []target
+	var groups []*group
+	for _, key := range keys {
+		value := cfg[key]
+		switch key {
+		case "targets":
+			for _, arg := range configList(value) {
+				t, err := parseTarget(arg)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", path, err)
+				}
+				targets = append(targets, t...)
+			}
+
+		case "groups":
+			list, ok := value.([]any)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: groups must be a list", path)
+			}
+			for _, item := range list {
+				g, err := configGroup(item)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", path, err)
+				}
+				groups = append(groups, g)
+			}
+
+		default:
+			flag := flags.Lookup(key)
+			if flag == nil {
+				return nil, nil, fmt.Errorf("%s: unknown option %s", path, key)
+			}
+			if flag.Changed {
+				continue
+			}
+			for _, v := range configList(value) {
+				if err = flags.Set(key, v); err != nil {
+					return nil, nil, fmt.Errorf("%s: invalid %s: %w", path, key, err)
+				}
+			}
+			// keep telling the command line values from the config ones
+			flag.Changed = false
+		}
+	}
+
+	return targets, groups, nil
+}
+
+// configGroup converts a config file group into the group
+func configGroup(item any) (*group, error) {
+	m, ok := item.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("group must be a mapping")
+	}
+
+	name, ok := m["name"]
+	if !ok || name == nil || fmt.Sprint(name) == "" {
+		return nil, fmt.Errorf("group must have a name")
+	}
+
+	g := &group{name: fmt.Sprint(name)}
+	for key, value := range m {
+		switch key {
+		case "name":
+		case "hosts":
+			for _, host := range configList(value) {
+				if err := g.setOption("host", host); err != nil {
+					return nil, fmt.Errorf("group %s: %w", g.name, err)
+				}
+			}
+		default:
+			if err := g.setOption(key, fmt.Sprint(value)); err != nil {
+				return nil, fmt.Errorf("group %s: %w", g.name, err)
+			}
+		}
+	}
+
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// configList converts a scalar or a list config value into the list of strings
+func configList(value any) []string {
+	list, ok := value.([]any)
+	if !ok {
+		return []string{fmt.Sprint(value)}
+	}
+
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		values = append(values, fmt.Sprint(v))
+	}
+	return values
+}
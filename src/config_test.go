@@ -0,0 +1,73 @@
+package src
+
+import (
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFlags returns the flags of a pinger, to read the config file into
+func testFlags(p *Ping) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	generalOptions, pingOptions, groupOptions := p.flagSets(&logOptions{})
+	flags.AddFlagSet(generalOptions)
+	flags.AddFlagSet(pingOptions)
+	flags.AddFlagSet(groupOptions)
+	return flags
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "pinger.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfig(t, `wait: 2s
+targets: [192.0.2.1]
+groups:
+  - name: cluster
+    group-mode: all
+    hosts: [192.0.2.100, 192.0.2.101]
+`)
+
+	p := &Ping{}
+	targets, groups, err := readConfigFile(path, testFlags(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.waitTimeout != 2*time.Second || len(targets) != 1 {
+		t.Errorf("wait %s with %d targets", p.waitTimeout, len(targets))
+	}
+	if len(groups) != 1 || groups[0].name != "cluster" || groups[0].mode != groupModeAll || len(groups[0].targets) != 2 {
+		t.Errorf("got groups %+v", groups)
+	}
+}
+
+func TestConfigGroupErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		group string
+	}{
+		{"no name", "hosts: [192.0.2.1]"},
+		{"empty name", "name: \"\"\n    hosts: [192.0.2.1]"},
+		{"no hosts", "name: cluster"},
+		{"unknown mode", "name: cluster\n    group-mode: bogus\n    hosts: [192.0.2.1]"},
+		{"mode with thresholds", "name: cluster\n    group-mode: any\n    group-alive: 1\n    hosts: [192.0.2.1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, "groups:\n  - "+tt.group+"\n")
+			if _, groups, err := readConfigFile(path, testFlags(&Ping{})); err == nil {
+				t.Errorf("no error, got %+v", groups[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package src
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const (
+	protocolICMP     = 1  // ICMP for IPv4
+	protocolIPv6ICMP = 58 // ICMP for IPv6
+
+	icmpHeaderSize  = 8     // echo message header
+	maxIPHeaderSize = 60    // the largest of IPv4 (with options) and IPv6 headers
+	maxPacketSize   = 65535 // IP total length limit
+	maxPayloadSize  = maxPacketSize - maxIPHeaderSize - icmpHeaderSize
+	minRecvBuffer   = 1500 // enough for the ICMP errors quoting the original packet
+	recvBatchSize   = 32   // messages read by a single batch call
+
+	recvBackoffMin  = 50 * time.Millisecond // delay after the first failed read of a socket
+	recvBackoffMax  = 5 * time.Second       // limit of the doubling delay
+	recvMaxFailures = 10                    // consecutive failed reads before giving up the socket
+	sendRetries     = 2                     // retries of a send failed for the lack of buffer space
+	sendBackoff     = 10 * time.Millisecond // delay before the first retry of a send, doubling
+
+	bindBackoffMin = 500 * time.Millisecond // delay before the first retry of opening a socket
+	bindBackoffMax = 30 * time.Second       // limit of the doubling delay
+
+	codeFragmentationNeeded = 4 // destination unreachable code of the packets too big with DF
+)
+
+// packetConn is the part of *icmp.PacketConn used by the pinger,
+// it allows running the pinger on top of a fake connection
+type packetConn interface {
+	ReadFrom(b []byte) (int, net.Addr, error)
+	WriteTo(b []byte, dst net.Addr) (int, error)
+	Close() error
+	LocalAddr() net.Addr
+}
+
+// batchReader reads several messages per call, see ipv4.PacketConn.ReadBatch
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// icmpConn is an unprivileged ICMP socket of a single address family
+type icmpConn struct {
+	conn      packetConn
+	proto     int              // protocol number used to parse replies
+	echoType  icmp.Type        // echo request type
+	replyType icmp.Type        // echo reply type
+	ttlType   icmp.Type        // time exceeded type
+	unreach   icmp.Type        // destination unreachable type
+	pid       uint16           // identifier of the outgoing echo requests
+	batch     batchReader      // batch reads of the socket, nil if unsupported
+	raw       bool             // raw socket, batch reads of IPv4 ones include the IP header
+	socket    *icmp.PacketConn // the socket for the platform specific calls, nil for the fakes
+
+	truncated bool // whether a read filling the buffer was reported, touched by the receiver only
+}
+
+func newICMP4Conn(conn packetConn, id uint16) *icmpConn {
+	c := newICMPConn(conn, id, protocolICMP, ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply)
+	c.ttlType = ipv4.ICMPTypeTimeExceeded
+	c.unreach = ipv4.ICMPTypeDestinationUnreachable
+	if pc, ok := conn.(*icmp.PacketConn); ok && pc.IPv4PacketConn() != nil && batchSupported() {
+		c.batch = pc.IPv4PacketConn()
+	}
+	return c
+}
+
+func newICMP6Conn(conn packetConn, id uint16) *icmpConn {
+	c := newICMPConn(conn, id, protocolIPv6ICMP, ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply)
+	c.ttlType = ipv6.ICMPTypeTimeExceeded
+	c.unreach = ipv6.ICMPTypeDestinationUnreachable
+	if pc, ok := conn.(*icmp.PacketConn); ok && pc.IPv6PacketConn() != nil && batchSupported() {
+		c.batch = pc.IPv6PacketConn()
+	}
+	return c
+}
+
+// newICMPConn wraps the socket sending the echo requests with the id, 0 for the process id.
+// The replies are matched to the requests by the id, as the raw sockets receive the replies
+// to every process on the host. On linux the unprivileged sockets are the exception, the
+// kernel assigns them a unique id, the local "port", and delivers them only their replies.
+func newICMPConn(conn packetConn, id uint16, proto int, echoType, replyType icmp.Type) *icmpConn {
+	c := &icmpConn{
+		conn:      conn,
+		proto:     proto,
+		echoType:  echoType,
+		replyType: replyType,
+	}
+
+	// linux assigns local "port" to the id of the packets, need to account for that
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if ok && runtime.GOOS == "linux" {
+		c.pid = uint16(addr.Port)
+	} else if id != 0 {
+		c.pid = id
+	} else {
+		c.pid = uint16(os.Getpid())
+	}
+	_, c.raw = conn.LocalAddr().(*net.IPAddr)
+	c.socket, _ = conn.(*icmp.PacketConn)
+
+	return c
+}
+
+// batchSupported returns whether the platform implements ReadBatch
+func batchSupported() bool {
+	return runtime.GOOS != "windows"
+}
+
+// rawNetworks maps the unprivileged socket networks to the raw ones
+var rawNetworks = map[string]string{
+	"udp4": "ip4:icmp",
+	"udp6": "ip6:ipv6-icmp",
+}
+
+// openSocket opens an unprivileged ICMP socket of the network, or a raw one
+// if requested or if the former is not permitted and the process may open raw ones
+func (p *Ping) openSocket(network, address string) (*icmp.PacketConn, error) {
+	// windows has no unprivileged ICMP sockets, the raw ones require an administrator
+	if !p.raw && runtime.GOOS != "windows" {
+		conn, err := icmp.ListenPacket(network, address)
+		if err == nil {
+			p.log.Info("Opened unprivileged ICMP socket", zap.String("network", network))
+			return conn, nil
+		}
+		if !errors.Is(err, os.ErrPermission) {
+			return nil, err
+		}
+
+		conn, rawErr := icmp.ListenPacket(rawNetworks[network], address)
+		if rawErr != nil {
+			return nil, socketPermissionError(err, false)
+		}
+		p.log.Warn("Unprivileged ICMP sockets are not permitted, fell back to raw ones", zap.Error(err))
+		p.log.Info("Opened raw ICMP socket", zap.String("network", rawNetworks[network]))
+		return conn, nil
+	}
+
+	conn, err := icmp.ListenPacket(rawNetworks[network], address)
+	if err != nil {
+		return nil, socketPermissionError(err, true)
+	}
+	p.log.Info("Opened raw ICMP socket", zap.String("network", rawNetworks[network]))
+	return conn, nil
+}
+
+// retryListen wraps the listen retrying it up to retryBind times, e.g. at boot before the
+// network is ready, with the delay doubling each time. The missing permission is final.
+func (p *Ping) retryListen(listen func(network string) (packetConn, error)) func(network string) (packetConn, error) {
+	return func(network string) (packetConn, error) {
+		for attempt := 0; ; attempt++ {
+			conn, err := listen(network)
+			if err == nil || attempt >= p.retryBind || errors.Is(err, os.ErrPermission) {
+				return conn, err
+			}
+
+			delay := min(bindBackoffMin<<attempt, bindBackoffMax)
+			p.log.Warn("Failed to open the socket, retrying",
+				zap.String("network", network),
+				zap.Error(err),
+				zap.Int("attempt", attempt+1),
+				zap.Duration("delay", delay))
+			time.Sleep(delay)
+		}
+	}
+}
+
+// socketPermissionError explains how to permit the ICMP sockets the process failed to open
+func socketPermissionError(err error, raw bool) error {
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	switch {
+	case runtime.GOOS == "windows":
+		return fmt.Errorf("%w: the raw ICMP sockets require running as an administrator", err)
+	case raw:
+		return fmt.Errorf("%w: the raw ICMP sockets require root or the CAP_NET_RAW capability", err)
+	case runtime.GOOS == "linux":
+		return fmt.Errorf("%w: the unprivileged ICMP sockets are not permitted for the group of the process, "+
+			"allow them with sysctl -w net.ipv4.ping_group_range=\"0 2147483647\" "+
+			"or grant the CAP_NET_RAW capability to use the raw ones", err)
+	default:
+		return fmt.Errorf("%w: the unprivileged ICMP sockets are not permitted, run as root to use the raw ones", err)
+	}
+}
+
+// remoteAddr returns the destination address of the ip in the zone for the socket,
+// nil without one in the probe modes
+func (c *icmpConn) remoteAddr(ip net.IP, zone string) net.Addr {
+	if c == nil {
+		return nil
+	}
+	if c.raw {
+		return &net.IPAddr{IP: ip, Zone: zone}
+	}
+	return &net.UDPAddr{IP: ip, Zone: zone}
+}
+
+// peerAddr returns the ip and the zone of the address a message was received from
+func peerAddr(peer net.Addr) (net.IP, string) {
+	switch addr := peer.(type) {
+	case *net.UDPAddr:
+		return addr.IP, addr.Zone
+	case *net.IPAddr:
+		return addr.IP, addr.Zone
+	}
+	return nil, ""
+}
+
+// stripIPv4Header returns the payload of the IPv4 packet
+func stripIPv4Header(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	if hl := int(data[0]&0x0f) << 2; hl <= len(data) {
+		return data[hl:]
+	}
+	return nil
+}
+
+// recvBufferSize returns the configured buffer size, by default fitting a reply with the payload
+func (p *Ping) recvBufferSize() int {
+	if p.recvBuffer > 0 {
+		return p.recvBuffer
+	}
+	return max(minRecvBuffer, maxIPHeaderSize+icmpHeaderSize+p.payloadSize)
+}
+
+// checkTruncated reports a read filling the whole buffer, which may have been cut short,
+// with a warning the first time and in the debug log afterwards
+func (p *Ping) checkTruncated(c *icmpConn, n, size int) {
+	if n < size {
+		return
+	}
+	if c.truncated {
+		p.log.Debug("Received a message filling the buffer", zap.Int("size", size))
+		return
+	}
+	c.truncated = true
+	p.log.Warn("Received a message filling the buffer, it may be truncated, consider a larger recv buffer", zap.Int("size", size))
+}
+
+// localAddress returns the address to bind the socket of the network to
+func (p *Ping) localAddress(network string) (string, error) {
+	v6 := network == "udp6"
+	if p.source != nil && (p.source.To4() == nil) == v6 {
+		if err := checkLocalAddress(p.source); err != nil {
+			return "", err
+		}
+
+		p.log.Info("Binding to source address", zap.Stringer("address", p.source))
+		return p.source.String(), nil
+	}
+
+	if p.iface == "" {
+		if v6 {
+			return "::", nil
+		}
+		return "0.0.0.0", nil
+	}
+
+	address, err := interfaceAddress(p.iface, v6)
+	if err != nil {
+		return "", err
+	}
+
+	p.log.Info("Binding to interface",
+		zap.String("interface", p.iface),
+		zap.String("address", address))
+
+	return address, nil
+}
+
+// checkLocalAddress ensures the ip is assigned to one of the local interfaces
+func checkLocalAddress(ip net.IP) error {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("source address %s is not assigned to any local interface", ip)
+}
+
+// interfaceAddress returns the first address of the interface in the family
+func interfaceAddress(name string, v6 bool) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	// prefer the routable addresses over the link-local ones
+	linkLocal := ""
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != v6 {
+			continue
+		}
+
+		if !ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP.String(), nil
+		}
+		if linkLocal == "" {
+			linkLocal = ipNet.IP.String() + "%" + name
+		}
+	}
+
+	if linkLocal != "" {
+		return linkLocal, nil
+	}
+
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+	return "", fmt.Errorf("interface %s has no %s address", name, family)
+}
+
+// setTTL sets the time to live (hop limit for IPv6) of the outgoing packets
+func setTTL(conn *icmp.PacketConn, ttl int) error {
+	if c := conn.IPv4PacketConn(); c != nil {
+		return c.SetTTL(ttl)
+	}
+	return conn.IPv6PacketConn().SetHopLimit(ttl)
+}
+
+// setTOS sets the type of service (traffic class for IPv6) of the outgoing packets
+func setTOS(conn *icmp.PacketConn, tos int) error {
+	if c := conn.IPv4PacketConn(); c != nil {
+		return c.SetTOS(tos)
+	}
+	return conn.IPv6PacketConn().SetTrafficClass(tos)
+}
+
+var (
+	errDontFragment = errors.New("don't fragment is not supported on this platform")
+	errBindToDevice = errors.New("binding to a device is not supported on this platform")
+	errRecvErr      = errors.New("queueing the ICMP errors is not supported on this platform")
+	errBroadcast    = errors.New("sending to the broadcast addresses is not supported on this platform")
+)
+
+// queuedError is an ICMP error the kernel reports to an unprivileged socket
+// through its error queue instead of delivering the message itself
+type queuedError struct {
+	data     []byte // our echo request the error is about
+	dst      net.IP // destination of the echo request
+	typ      uint8  // ICMP type of the error
+	code     uint8  // ICMP code of the error
+	info     uint32 // the next-hop MTU for the packets too big
+	offender net.IP // the router which reported the error
+}
+
+// fragmentationNeeded returns the next-hop MTU if the message reports a packet too big
+// to be forwarded without the fragmentation, data is the whole message
+func (c *icmpConn) fragmentationNeeded(rm *icmp.Message, data []byte) (int, []byte, bool) {
+	switch body := rm.Body.(type) {
+	case *icmp.PacketTooBig:
+		return body.MTU, body.Data, true
+	case *icmp.DstUnreach:
+		// the next-hop MTU is the last half of the otherwise unused header word
+		if rm.Type == ipv4.ICMPTypeDestinationUnreachable && rm.Code == codeFragmentationNeeded && len(data) >= icmpHeaderSize {
+			return int(binary.BigEndian.Uint16(data[6:8])), body.Data, true
+		}
+	}
+	return 0, nil, false
+}
+
+// tooBig returns whether the queued error reports a packet too big
+// to be forwarded without the fragmentation, its info is the next-hop MTU then
+func (c *icmpConn) tooBig(e queuedError) bool {
+	if c.proto == protocolICMP {
+		return ipv4.ICMPType(e.typ) == ipv4.ICMPTypeDestinationUnreachable && e.code == codeFragmentationNeeded
+	}
+	return ipv6.ICMPType(e.typ) == ipv6.ICMPTypePacketTooBig
+}
+
+// quotedEcho returns the destination of the packet quoted in an ICMP error,
+// false unless the packet is an echo request of the socket
+func (c *icmpConn) quotedEcho(data []byte) (net.IP, bool) {
+	var dst net.IP
+	var headerLen int
+	if c.proto == protocolICMP {
+		h, err := ipv4.ParseHeader(data)
+		if err != nil {
+			return nil, false
+		}
+		dst, headerLen = h.Dst, h.Len
+	} else {
+		h, err := ipv6.ParseHeader(data)
+		if err != nil {
+			return nil, false
+		}
+		dst, headerLen = h.Dst, ipv6.HeaderLen
+	}
+
+	if headerLen > len(data) {
+		return nil, false
+	}
+	return dst, c.ownEcho(data[headerLen:])
+}
+
+// ownEcho checks whether the ICMP message is an echo request sent by the socket,
+// so the errors caused by the other processes are not taken for ours
+func (c *icmpConn) ownEcho(data []byte) bool {
+	rm, err := icmp.ParseMessage(c.proto, data)
+	if err != nil || rm.Type != c.echoType {
+		return false
+	}
+	echo, ok := rm.Body.(*icmp.Echo)
+	return ok && uint16(echo.ID) == c.pid
+}
+
+// icmpType returns the ICMP type of the socket family
+func (c *icmpConn) icmpType(t uint8) icmp.Type {
+	if c.proto == protocolICMP {
+		return ipv4.ICMPType(t)
+	}
+	return ipv6.ICMPType(t)
+}
+
+// unreachableReasons describe the destination unreachable codes of each family
+var unreachableReasons = map[int]map[int]string{
+	protocolICMP: {
+		0:  "network unreachable",
+		1:  "host unreachable",
+		2:  "protocol unreachable",
+		3:  "port unreachable",
+		5:  "source route failed",
+		6:  "destination network unknown",
+		7:  "destination host unknown",
+		9:  "network administratively prohibited",
+		10: "host administratively prohibited",
+		13: "communication administratively prohibited",
+	},
+	protocolIPv6ICMP: {
+		0: "no route to destination",
+		1: "communication administratively prohibited",
+		2: "beyond scope of source address",
+		3: "address unreachable",
+		4: "port unreachable",
+		5: "source address failed ingress/egress policy",
+		6: "reject route to destination",
+	},
+}
+
+// unreachableReason describes the destination unreachable code
+func (c *icmpConn) unreachableReason(code int) string {
+	if reason, ok := unreachableReasons[c.proto][code]; ok {
+		return reason
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// transientSendError returns whether the send failed for the local congestion, worth a retry
+func transientSendError(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN)
+}
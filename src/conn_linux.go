@@ -0,0 +1,164 @@
+package src
+
+import (
+	"encoding/binary"
+	"golang.org/x/net/icmp"
+	"net"
+	"syscall"
+)
+
+// setDontFragment prohibits the fragmentation of the outgoing packets
+func setDontFragment(conn *icmp.PacketConn) error {
+	level, opt := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER
+	if conn.IPv4PacketConn() == nil {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER
+	}
+
+	return controlSocket(conn, errDontFragment, func(fd int) error {
+		// the DO values of both families are the same
+		return syscall.SetsockoptInt(fd, level, opt, syscall.IP_PMTUDISC_DO)
+	})
+}
+
+// enableBroadcast allows sending to the broadcast addresses
+func enableBroadcast(conn *icmp.PacketConn) error {
+	return controlSocket(conn, errBroadcast, func(fd int) error {
+		return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	})
+}
+
+// bindToDevice makes the packets leave through the interface regardless of the routes
+func bindToDevice(conn *icmp.PacketConn, iface string) error {
+	return controlSocket(conn, errBindToDevice, func(fd int) error {
+		return syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+	})
+}
+
+// enableRecvErr makes the kernel queue the ICMP errors of an unprivileged socket,
+// otherwise they are only seen as the errno of the next receive
+func enableRecvErr(conn *icmp.PacketConn) error {
+	level, opt := syscall.IPPROTO_IP, syscall.IP_RECVERR
+	if conn.IPv4PacketConn() == nil {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR
+	}
+
+	return controlSocket(conn, errRecvErr, func(fd int) error {
+		return syscall.SetsockoptInt(fd, level, opt, 1)
+	})
+}
+
+// readErrQueue reads the ICMP errors queued to the socket without blocking
+func readErrQueue(conn *icmp.PacketConn) ([]queuedError, error) {
+	var errs []queuedError
+	err := controlSocket(conn, errRecvErr, func(fd int) error {
+		buf := make([]byte, minRecvBuffer)
+		oob := make([]byte, syscall.CmsgSpace(extendedErrSize+syscall.SizeofSockaddrInet6))
+		for {
+			n, oobn, _, from, err := syscall.Recvmsg(fd, buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err == syscall.EAGAIN {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			e, ok := parseExtendedErr(oob[:oobn])
+			if !ok {
+				continue
+			}
+			e.data = append([]byte(nil), buf[:n]...)
+			e.dst = sockaddrIP(from)
+			errs = append(errs, e)
+		}
+	})
+	return errs, err
+}
+
+const (
+	extendedErrSize = 16 // struct sock_extended_err
+	originICMP      = 2  // SO_EE_ORIGIN_ICMP
+	originICMP6     = 3  // SO_EE_ORIGIN_ICMP6
+)
+
+// parseExtendedErr extracts the ICMP error from the control messages of the error queue
+func parseExtendedErr(oob []byte) (queuedError, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return queuedError{}, false
+	}
+
+	for _, m := range msgs {
+		if !(m.Header.Level == syscall.SOL_IP && m.Header.Type == syscall.IP_RECVERR) &&
+			!(m.Header.Level == syscall.SOL_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) {
+			continue
+		}
+
+		// errno, origin, type, code, pad, info, data, followed by the offender address
+		d := m.Data
+		if len(d) < extendedErrSize || (d[4] != originICMP && d[4] != originICMP6) {
+			continue
+		}
+		return queuedError{
+			typ:      d[5],
+			code:     d[6],
+			info:     binary.NativeEndian.Uint32(d[8:12]),
+			offender: offenderIP(d[extendedErrSize:]),
+		}, true
+	}
+	return queuedError{}, false
+}
+
+// offenderIP extracts the ip of the sockaddr following the extended error
+func offenderIP(sa []byte) net.IP {
+	if len(sa) < 2 {
+		return nil
+	}
+	switch binary.NativeEndian.Uint16(sa) {
+	case syscall.AF_INET:
+		if len(sa) >= 8 {
+			return net.IP(append([]byte(nil), sa[4:8]...))
+		}
+	case syscall.AF_INET6:
+		if len(sa) >= 24 {
+			return net.IP(append([]byte(nil), sa[8:24]...))
+		}
+	}
+	return nil
+}
+
+// sockaddrIP returns the ip of the socket address
+func sockaddrIP(sa syscall.Sockaddr) net.IP {
+	switch a := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(a.Addr[:])
+	case *syscall.SockaddrInet6:
+		return net.IP(a.Addr[:])
+	}
+	return nil
+}
+
+// controlSocket runs fn on the file descriptor of the socket,
+// unsupported is returned if the socket does not expose one
+func controlSocket(conn *icmp.PacketConn, unsupported error, fn func(fd int) error) error {
+	var pc net.PacketConn
+	if c := conn.IPv4PacketConn(); c != nil {
+		pc = c.PacketConn
+	} else {
+		pc = conn.IPv6PacketConn().PacketConn
+	}
+
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return unsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	if err = rc.Control(func(fd uintptr) { serr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package src
+
+import "golang.org/x/net/icmp"
+
+// setDontFragment prohibits the fragmentation of the outgoing packets
+func setDontFragment(*icmp.PacketConn) error {
+	return errDontFragment
+}
+
+// enableRecvErr makes the kernel queue the ICMP errors of an unprivileged socket
+func enableRecvErr(*icmp.PacketConn) error {
+	return errRecvErr
+}
+
+// readErrQueue reads the ICMP errors queued to the socket without blocking
+func readErrQueue(*icmp.PacketConn) ([]queuedError, error) {
+	return nil, errRecvErr
+}
+
+// enableBroadcast allows sending to the broadcast addresses
+func enableBroadcast(*icmp.PacketConn) error {
+	return errBroadcast
+}
+
+// bindToDevice makes the packets leave through the interface regardless of the routes
+func bindToDevice(*icmp.PacketConn, string) error {
+	return errBindToDevice
+}
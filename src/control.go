@@ -0,0 +1,174 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// controlServer accepts the line commands over a unix socket:
+//
+//	status                  the current state as JSON
+//	add <host> [group]      start pinging the host, in the command line group by default
+//	remove <ip>             stop pinging the host
+//	pause                   suspend pinging
+//	resume                  resume pinging
+//
+// each command is answered with a line, "ok", the status or "error: <reason>".
+// The hosts added or removed over the socket are reset by a config reload.
+type controlServer struct {
+	p        *Ping
+	path     string
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool // open connections, closed on shutdown
+	wg    sync.WaitGroup
+}
+
+func newControlServer(p *Ping, path string) *controlServer {
+	return &controlServer{p: p, path: path, conns: make(map[net.Conn]bool)}
+}
+
+func (s *controlServer) start() {
+	if s == nil {
+		return
+	}
+
+	// a socket left by a crashed instance is removed, a live one is not taken over
+	if conn, err := net.Dial("unix", s.path); err == nil {
+		_ = conn.Close()
+		s.p.log.Error("Control socket is in use by another process", zap.String("path", s.path))
+		return
+	}
+	_ = os.Remove(s.path)
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		s.p.log.Error("Failed to open the control socket", zap.String("path", s.path), zap.Error(err))
+		return
+	}
+	if err = os.Chmod(s.path, 0o600); err != nil {
+		s.p.log.Warn("Failed to restrict the control socket", zap.String("path", s.path), zap.Error(err))
+	}
+	s.listener = listener
+	s.p.log.Info("Serving control socket", zap.String("path", s.path))
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				s.p.log.Error("Failed to accept a control connection", zap.Error(err))
+				continue
+			}
+
+			s.mu.Lock()
+			s.conns[conn] = true
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go s.serve(conn)
+		}
+	}()
+}
+
+// serve answers the commands of the connection until it is closed
+func (s *controlServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(conn, s.p.handleControl(line)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *controlServer) close() {
+	if s == nil || s.listener == nil {
+		return
+	}
+
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// handleControl executes a control command, returning the reply
+func (p *Ping) handleControl(line string) string {
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
+	p.log.Debug("Control command", zap.String("command", line))
+
+	var err error
+	switch {
+	case command == "status" && len(args) == 0:
+		var b []byte
+		if b, err = json.Marshal(p.snapshot()); err == nil {
+			return string(b)
+		}
+	case command == "add" && (len(args) == 1 || len(args) == 2):
+		group := ""
+		if len(args) == 2 {
+			group = args[1]
+		}
+		err = p.AddTarget(args[0], group)
+	case command == "remove" && len(args) == 1:
+		err = p.RemoveTarget(args[0])
+	case command == "pause" && len(args) == 0:
+		p.setPaused(true)
+	case command == "resume" && len(args) == 0:
+		p.setPaused(false)
+	default:
+		err = fmt.Errorf("unknown command %s", line)
+	}
+
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "ok"
+}
+
+// setPaused suspends or resumes pinging
+func (p *Ping) setPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if paused && !p.paused {
+		p.log.Info("Pausing pinging")
+	} else if !paused && p.paused {
+		p.log.Info("Resuming pinging")
+	}
+	p.paused = paused
+}
+
+func (p *Ping) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
@@ -0,0 +1,40 @@
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandleControl(t *testing.T) {
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+
+	for _, tt := range []struct {
+		command string
+		want    string
+	}{
+		{"add 192.0.2.2=backup:alive=1", "ok"},
+		{"add 192.0.2.1", "error: host 192.0.2.1 is already pinged in the command line group"},
+		{"add 192.0.2.3 nosuch", "error: no group nosuch"},
+		{"add 2001:db8::1", "error: no socket for the address family of 2001:db8::1"},
+		{"remove 192.0.2.1", "ok"},
+		{"remove 192.0.2.1", "error: host 192.0.2.1 is not pinged"},
+		{"remove nonsense", "error: invalid address nonsense"},
+		{"pause", "ok"},
+		{"status", `{"alive":false,"paused":true,`},
+		{"resume", "ok"},
+		{"pause now", "error: unknown command pause now"},
+	} {
+		if got := p.handleControl(tt.command); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.command, got, tt.want)
+		}
+	}
+
+	ri, ok := p.send["192.0.2.2"]
+	if !ok || ri.label != "backup" || ri.aliveCount != 1 {
+		t.Fatalf("added host %+v", ri)
+	}
+	g := p.groups[0]
+	if g.size != 1 || g.groupAlive != 1 || len(g.targets) != 1 || p.paused {
+		t.Errorf("got group of %d hosts alive on %d with %d targets, paused %v", g.size, g.groupAlive, len(g.targets), p.paused)
+	}
+}
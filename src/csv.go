@@ -0,0 +1,76 @@
+package src
+
+import (
+	"encoding/csv"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// csvHeader names the columns of the round log
+var csvHeader = []string{"timestamp", "ip", "label", "group", "success", "rtt_ms"}
+
+// csvLog appends a row per pinged host per round to a CSV file for the offline
+// analysis, all the methods are no-ops on a nil receiver
+type csvLog struct {
+	log  *zap.Logger
+	file *os.File
+	w    *csv.Writer
+}
+
+// newCSVLog opens the file for appending, writing the header to a new one
+func newCSVLog(log *zap.Logger, path string) *csvLog {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Error("Failed to open the CSV file", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+
+	c := &csvLog{log: log, file: file, w: csv.NewWriter(file)}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		_ = c.w.Write(csvHeader)
+	}
+	return c
+}
+
+// writeRound appends the results of the hosts pinged in the round,
+// the rtt is left empty for the hosts which did not reply
+func (c *csvLog) writeRound(hosts map[string]*remoteInfo, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(hosts))
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ts := now.Format(time.RFC3339Nano)
+	for _, key := range keys {
+		ri := hosts[key]
+		if ri.skipped {
+			continue
+		}
+
+		rtt := ""
+		if ri.gotReply && ri.rtt.count > 0 {
+			rtt = strconv.FormatFloat(float64(ri.rtt.last)/float64(time.Millisecond), 'f', -1, 64)
+		}
+		_ = c.w.Write([]string{ts, key, ri.label, ri.group.name, strconv.FormatBool(ri.gotReply), rtt})
+	}
+
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.log.Error("Failed to write to the CSV file", zap.String("path", c.file.Name()), zap.Error(err))
+	}
+}
+
+func (c *csvLog) close() {
+	if c == nil {
+		return
+	}
+	_ = c.file.Close()
+}
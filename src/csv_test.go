@@ -0,0 +1,42 @@
+package src
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCSVWriteRound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rounds.csv")
+	g := &group{name: "uplinks"}
+	replied := &remoteInfo{label: "core, router", group: g, gotReply: true}
+	replied.rtt.add(1500 * time.Microsecond)
+	hosts := map[string]*remoteInfo{
+		"192.0.2.1": replied,
+		"192.0.2.2": {group: g},
+		"192.0.2.3": {group: g, skipped: true},
+	}
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	// the header is only written to a new file
+	for range 2 {
+		c := newCSVLog(zap.NewNop(), path)
+		c.writeRound(hosts, now)
+		c.close()
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := `192.0.2.1,"core, router",uplinks,true,1.5
+2023-11-14T22:13:20Z,192.0.2.2,,uplinks,false,
+`
+	want := "timestamp,ip,label,group,success,rtt_ms\n" +
+		"2023-11-14T22:13:20Z," + rows + "2023-11-14T22:13:20Z," + rows
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package src
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+const (
+	eventHost  = "host"
+	eventGroup = "group"
+)
+
+// streamEvent is a line of the event stream
+type streamEvent struct {
+	Time  time.Time `json:"ts"`
+	Type  string    `json:"type"`            // host or group
+	IP    string    `json:"ip,omitempty"`    // the host, or the host which caused the group transition
+	Label string    `json:"label,omitempty"` // the label of the host
+	Group string    `json:"group,omitempty"` // the group of the host, or the group itself
+	State string    `json:"state"`           // the new state
+}
+
+// eventStream writes the host and group state transitions as JSON lines,
+// separate from the logs for the consumers to react on. The writes happen
+// with the pinger locked, so the lines never interleave. All the methods
+// are no-ops on a nil receiver.
+type eventStream struct {
+	log *zap.Logger
+	enc *json.Encoder
+}
+
+func newEventStream(log *zap.Logger, w io.Writer) *eventStream {
+	return &eventStream{log: log, enc: json.NewEncoder(w)}
+}
+
+// host writes the transition of the host to the state
+func (s *eventStream) host(ri *remoteInfo, state string) {
+	if s == nil {
+		return
+	}
+	s.write(streamEvent{Type: eventHost, IP: ri.address(), Label: ri.label, Group: ri.group.name, State: state})
+}
+
+// group writes the transition of the group to the state
+func (s *eventStream) group(g *group, state string) {
+	if s == nil {
+		return
+	}
+	s.write(streamEvent{Type: eventGroup, IP: g.lastChanged, Group: g.name, State: state})
+}
+
+func (s *eventStream) write(e streamEvent) {
+	e.Time = time.Now()
+	if err := s.enc.Encode(e); err != nil {
+		s.log.Debug("Failed to write the event", zap.Error(err))
+	}
+}
@@ -0,0 +1,46 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestEventStream(t *testing.T) {
+	var out bytes.Buffer
+	p, conn := newTestPing(t, func(p *Ping) {
+		p.groups = []*group{{name: "uplinks", targets: []target{{ip: net.ParseIP("192.0.2.2")}}}}
+	}, "192.0.2.1=core")
+	p.events = newEventStream(p.log, &out)
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++--", "192.0.2.2": "----"})
+
+	var got []streamEvent
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var e streamEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event %+v has no time", e)
+		}
+		got = append(got, e)
+	}
+
+	want := []streamEvent{
+		{Type: eventHost, IP: "192.0.2.1", Label: "core", State: stateAlive},
+		{Type: eventGroup, IP: "192.0.2.1", State: stateAlive},
+		{Type: eventHost, IP: "192.0.2.1", Label: "core", State: stateDead},
+		{Type: eventGroup, IP: "192.0.2.1", State: stateDead},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		got[i].Time = want[i].Time
+		if got[i] != want[i] {
+			t.Errorf("event %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// group is a set of hosts with its own alive/dead logic and commands
+type group struct {
+	name       string   // empty for the group configured by the command line
+	groupAlive int      // weight of alive hosts to consider the group alive, 0 for all
+	allAlive   bool     // whether groupAlive follows the weight of the hosts
+	groupDead  int      // weight of alive hosts to consider the group dead
+	mode       string   // any or all setting the thresholds from the weight, count or empty for the given ones
+	cmdAlive   []string // commands to run in order when Alive
+	cmdDead    []string // commands to run in order when Dead
+	targets    []target // member hosts
+
+	initialCommand *bool // overrides whether to run the command of the initial state, if set
+
+	size          int       // number of member hosts
+	weight        int       // total weight of the member hosts
+	totalAlive    int       // number of alive member hosts
+	upWeight      int       // total weight of the alive member hosts, compared to the thresholds
+	unknownWeight int       // total weight of the member hosts in the unknown state
+	isTotalAlive  bool      // the groups start dead, so the first crossing of the alive threshold always fires
+	wasAlive      bool      // whether the group has ever been alive
+	assessed      bool      // whether the initial state of the group is settled
+	lastCommand   time.Time // when the transition command last fired
+	firedState    string    // state of the transition command last fired
+	lastChanged   string    // ip of the last member host which changed its state
+	pendingRounds int       // consecutive rounds spent past the threshold
+}
+
+// logFields returns the fields identifying the group in logs
+func (g *group) logFields() []zap.Field {
+	if g.name == "" {
+		return nil
+	}
+	return []zap.Field{zap.String("group", g.name)}
+}
+
+// allGroups returns the command line group of the targets, if any, followed by the groups.
+// Starting without hosts the empty command line group takes the hosts added at runtime.
+func (p *Ping) allGroups(targets []target, groups []*group) []*group {
+	if len(targets) == 0 && (len(groups) > 0 || !p.emptyAllowed()) {
+		return groups
+	}
+
+	return append([]*group{{
+		groupAlive: p.groupAlive,
+		groupDead:  p.groupDead,
+		mode:       p.groupMode,
+		cmdAlive:   p.cmdAlive,
+		cmdDead:    p.cmdDead,
+		targets:    targets,
+	}}, groups...)
+}
+
+// emptyAllowed returns whether the pinger may start without hosts
+func (p *Ping) emptyAllowed() bool {
+	return p.allowEmpty || p.controlSocket != ""
+}
+
+// describe returns the group name for the error messages
+func (g *group) describe() string {
+	if g.name == "" {
+		return "the command line group"
+	}
+	return "group " + g.name
+}
+
+// checkGroupOverlap rejects the hosts listed in more than one group,
+// as a host is pinged once it can only be counted by a single group
+func checkGroupOverlap(targets []target, groups []*group) error {
+	owners := make(map[string]*group)
+	for _, g := range append([]*group{{targets: targets}}, groups...) {
+		for _, t := range g.targets {
+			key := t.address()
+			if owner, ok := owners[key]; ok && owner != g {
+				return fmt.Errorf("host %s is listed in both %s and %s", key, owner.describe(), g.describe())
+			}
+			owners[key] = g
+		}
+	}
+	return nil
+}
+
+// duplicateHosts returns the hosts listed more than once, e.g. both in a range
+// and on their own, which are pinged and counted once
+func duplicateHosts(targets []target, groups []*group) []string {
+	seen := make(map[string]bool)
+	var duplicates []string
+	for _, g := range append([]*group{{targets: targets}}, groups...) {
+		for _, t := range g.targets {
+			key := t.address()
+			if seen[key] && !slices.Contains(duplicates, key) {
+				duplicates = append(duplicates, key)
+			}
+			seen[key] = true
+		}
+	}
+	return duplicates
+}
+
+// updateGroups recounts the group members and defaults the alive thresholds to their weight,
+// the any and all modes set both thresholds from it
+func (p *Ping) updateGroups() {
+	for _, g := range p.groups {
+		g.size, g.weight, g.unknownWeight = 0, 0, 0
+	}
+	for _, ri := range p.send {
+		ri.group.size++
+		ri.group.weight += ri.weight
+		if ri.unknown {
+			ri.group.unknownWeight += ri.weight
+		}
+	}
+
+	for _, g := range p.groups {
+		switch g.mode {
+		case groupModeAny:
+			g.groupAlive, g.groupDead = 1, 0
+			continue
+		case groupModeAll:
+			g.groupAlive, g.groupDead = g.weight, max(g.weight-1, 0)
+			continue
+		}
+
+		if g.groupAlive == 0 {
+			g.allAlive = true
+		}
+		if g.allAlive {
+			g.groupAlive = g.weight
+		}
+	}
+}
+
+const (
+	groupModeCount = "count" // the group alive and dead thresholds as given
+	groupModeAny   = "any"   // alive with any host alive, dead with all of them dead
+	groupModeAll   = "all"   // alive with all the hosts alive, dead with any of them dead
+)
+
+// checkGroupMode rejects the unknown modes and the thresholds given along with the modes setting them
+func checkGroupMode(mode string, groupAlive, groupDead int) error {
+	switch mode {
+	case "", groupModeCount:
+		return nil
+	case groupModeAny, groupModeAll:
+		if groupAlive != 0 || groupDead != 0 {
+			return fmt.Errorf("group mode %s sets group alive and dead itself", mode)
+		}
+		return nil
+	}
+	return fmt.Errorf("group mode must be %s, %s or %s", groupModeAny, groupModeAll, groupModeCount)
+}
+
+// count adds the alive host to the totals of the group
+func (g *group) count(ri *remoteInfo) {
+	g.totalAlive += 1
+	g.upWeight += ri.weight
+}
+
+// uncount removes the host no longer alive from the totals of the group
+func (g *group) uncount(ri *remoteInfo) {
+	g.totalAlive -= 1
+	g.upWeight -= ri.weight
+}
+
+// moveToGroup makes the host a member of the group, carrying its alive count over
+func (ri *remoteInfo) moveToGroup(g *group) {
+	if ri.group == g {
+		return
+	}
+
+	if ri.counted {
+		ri.group.uncount(ri)
+		g.count(ri)
+	}
+	if ri.unknown {
+		ri.group.unknownWeight -= ri.weight
+		g.unknownWeight += ri.weight
+	}
+	ri.group = g
+}
+
+// known stops ignoring the host assessed alive or dead in the group thresholds
+func (g *group) known(ri *remoteInfo) {
+	if ri.unknown {
+		ri.unknown = false
+		g.unknownWeight -= ri.weight
+	}
+}
+
+func (p *Ping) handleHostAlive(ri *remoteInfo) {
+	if ri.counted {
+		return
+	}
+	ri.counted = true
+
+	g := ri.group
+	g.known(ri)
+	g.count(ri)
+	g.lastChanged = ri.address()
+	if p.groupStableRounds == 0 && g.crossedThreshold() {
+		p.transitionGroup(g)
+	}
+}
+
+func (p *Ping) handleHostDead(ri *remoteInfo) {
+	if !ri.counted && !ri.unknown {
+		return
+	}
+
+	g := ri.group
+	if ri.counted {
+		ri.counted = false
+		g.uncount(ri)
+	}
+	g.known(ri)
+	g.lastChanged = ri.address()
+	if p.groupStableRounds == 0 && g.crossedThreshold() {
+		p.transitionGroup(g)
+	}
+}
+
+// crossedThreshold returns whether the alive count calls for leaving the current state,
+// a group without hosts has no state to leave. The unknown hosts may yet turn out
+// alive, so they keep the group from dying, but do not make it alive either.
+func (g *group) crossedThreshold() bool {
+	if g.size == 0 {
+		return false
+	}
+	if g.isTotalAlive {
+		return g.upWeight+g.unknownWeight <= g.groupDead
+	}
+	return g.upWeight >= g.groupAlive
+}
+
+// settleGroups transitions the groups left past their thresholds by a change
+// of the configuration, instead of waiting for a host to change its state
+func (p *Ping) settleGroups() {
+	if p.groupStableRounds > 0 {
+		return
+	}
+
+	for _, g := range p.groups {
+		if g.crossedThreshold() {
+			p.transitionGroup(g)
+		}
+	}
+}
+
+// checkStableGroups transitions the groups which stayed past their thresholds
+// for the required number of consecutive rounds, called once per round
+func (p *Ping) checkStableGroups() {
+	if p.groupStableRounds == 0 {
+		return
+	}
+
+	for _, g := range p.groups {
+		if !g.crossedThreshold() {
+			g.pendingRounds = 0
+			continue
+		}
+
+		g.pendingRounds += 1
+		if g.pendingRounds >= p.groupStableRounds {
+			g.pendingRounds = 0
+			p.transitionGroup(g)
+		}
+	}
+}
+
+// assessGroups settles the initial state of the groups once all their hosts were
+// pinged enough times to reach either count and no transition is pending,
+// optionally running the command of that state unless it has already fired
+func (p *Ping) assessGroups() {
+	pending := make(map[*group]bool)
+	for _, ri := range p.send {
+		if ri.sent < int(max(ri.aliveCount, ri.deadCount)) {
+			pending[ri.group] = true
+		}
+	}
+
+	for _, g := range p.groups {
+		if g.assessed || g.size == 0 || pending[g] || g.pendingRounds > 0 {
+			continue
+		}
+		g.assessed = true
+
+		// a cold start into the dead state fires no transition, so it is reported here
+		state, commands, webhook := stateDead, g.cmdDead, p.deadWebhook
+		if g.isTotalAlive {
+			state, commands, webhook = stateAlive, g.cmdAlive, p.aliveWebhook
+			p.log.Info("Assessed the initial state", append(g.logFields(), zap.String("state", state))...)
+		} else {
+			p.log.Warn("Assessed the initial state", append(g.logFields(), zap.String("state", state))...)
+		}
+
+		initial := p.initialCommand
+		if g.initialCommand != nil {
+			initial = *g.initialCommand
+		}
+		if initial && g.firedState != state {
+			p.fireTransition(g, state, commands, webhook)
+		}
+	}
+}
+
+// transitionGroup flips the state of the group
+func (p *Ping) transitionGroup(g *group) {
+	if g.isTotalAlive {
+		p.log.Info("Transitioning to dead state", g.logFields()...)
+		p.events.group(g, stateDead)
+		p.fireTransition(g, stateDead, g.cmdDead, p.deadWebhook)
+		g.isTotalAlive = false
+		return
+	}
+
+	p.log.Info("Transitioning to alive state", g.logFields()...)
+	p.events.group(g, stateAlive)
+	p.fireTransition(g, stateAlive, g.cmdAlive, p.aliveWebhook)
+	g.isTotalAlive = true
+	g.wasAlive = true
+}
+
+// fireTransition runs the commands and posts the webhook of a group transition,
+// unless another transition of the group fired them within the cooldown
+func (p *Ping) fireTransition(g *group, state string, commands []string, webhook string) {
+	now := time.Now()
+	if p.cmdCooldown > 0 && !g.lastCommand.IsZero() && now.Sub(g.lastCommand) < p.cmdCooldown {
+		p.log.Info("Suppressing the command during the cooldown",
+			append(g.logFields(), zap.String("state", state))...)
+		return
+	}
+	g.lastCommand = now
+	g.firedState = state
+
+	e := p.newEvent(g, g.lastChanged, state)
+	for _, command := range commands {
+		p.runCommand(command, e)
+	}
+	p.postWebhook(webhook, e)
+}
+
+// replaySuppressed fires the commands of the groups whose state changed during the cooldown
+// and differs from the last fired one, so the commands always end up at the current state
+func (p *Ping) replaySuppressed() {
+	if p.cmdCooldown == 0 {
+		return
+	}
+
+	for _, g := range p.groups {
+		if g.firedState == "" || time.Since(g.lastCommand) < p.cmdCooldown {
+			continue
+		}
+
+		if g.isTotalAlive && g.firedState != stateAlive {
+			p.log.Info("Replaying the command suppressed by the cooldown", g.logFields()...)
+			p.fireTransition(g, stateAlive, g.cmdAlive, p.aliveWebhook)
+		} else if !g.isTotalAlive && g.firedState != stateDead {
+			p.log.Info("Replaying the command suppressed by the cooldown", g.logFields()...)
+			p.fireTransition(g, stateDead, g.cmdDead, p.deadWebhook)
+		}
+	}
+}
+
+// readGroupsFile loads the groups from an ini-like file:
+//
+//	[name]
+//	group-alive = 2
+//	group-dead = 0
+//	group-mode = count
+//	alive-cmd = birdc enable provider1
+//	dead-cmd = birdc disable provider1
+//	dead-cmd = logger provider1 is down
+//	initial-command = true
+//	host = 192.0.2.1
+//	host = 192.0.2.2:alive=5,weight=2
+//
+// the commands repeated run in order, blank lines and # comments are ignored
+func readGroupsFile(path string) ([]*group, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var groups []*group
+	var g *group
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			g = &group{name: strings.TrimSpace(text[1 : len(text)-1])}
+			groups = append(groups, g)
+			continue
+		}
+
+		if g == nil {
+			return nil, fmt.Errorf("%s:%d: option outside of a group", path, line)
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, line)
+		}
+		if err = g.setOption(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if err = g.check(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return groups, nil
+}
+
+// check rejects the groups without hosts and the thresholds not matching the mode
+func (g *group) check() error {
+	if len(g.targets) == 0 {
+		return fmt.Errorf("group %s has no hosts", g.name)
+	}
+	if err := checkGroupMode(g.mode, g.groupAlive, g.groupDead); err != nil {
+		return fmt.Errorf("group %s: %w", g.name, err)
+	}
+	return nil
+}
+
+func (g *group) setOption(key, value string) error {
+	switch key {
+	case "group-alive", "group-dead":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s: %s", key, value)
+		}
+		if key == "group-alive" {
+			g.groupAlive = n
+		} else {
+			g.groupDead = n
+		}
+	case "group-mode":
+		g.mode = value
+	case "initial-command":
+		initial, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", key, value)
+		}
+		g.initialCommand = &initial
+	case "alive-cmd":
+		g.cmdAlive = append(g.cmdAlive, value)
+	case "dead-cmd":
+		g.cmdDead = append(g.cmdDead, value)
+	case "host":
+		targets, err := parseTarget(value)
+		if err != nil {
+			return err
+		}
+		g.targets = append(g.targets, targets...)
+	default:
+		return fmt.Errorf("unknown option %s", key)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package src
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadGroupsFile(t *testing.T) {
+	content := `# uplinks
+[provider1]
+group-alive = 2
+group-dead = 0
+alive-cmd = birdc enable provider1
+dead-cmd = birdc disable provider1
+dead-cmd = logger provider1 is down
+host = 192.0.2.1
+host = 192.0.2.2:alive=5
+
+[provider2]
+host = 198.51.100.0/31
+`
+	path := filepath.Join(t.TempDir(), "groups")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := readGroupsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+
+	g := groups[0]
+	if g.name != "provider1" || g.groupAlive != 2 || g.groupDead != 0 {
+		t.Errorf("got group %s alive %d dead %d", g.name, g.groupAlive, g.groupDead)
+	}
+	if !slices.Equal(g.cmdAlive, []string{"birdc enable provider1"}) ||
+		!slices.Equal(g.cmdDead, []string{"birdc disable provider1", "logger provider1 is down"}) {
+		t.Errorf("got commands %q and %q", g.cmdAlive, g.cmdDead)
+	}
+	if len(g.targets) != 2 || g.targets[1].opts.aliveCount != 5 {
+		t.Errorf("got targets %+v", g.targets)
+	}
+	if len(groups[1].targets) != 2 {
+		t.Errorf("got %d targets of %s, want 2", len(groups[1].targets), groups[1].name)
+	}
+}
+
+func TestReadGroupsFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"option outside of a group", "host = 192.0.2.1\n", "groups:1: option outside of a group"},
+		{"missing value", "[a]\nhost 192.0.2.1\n", "groups:2: expected key = value"},
+		{"unknown option", "[a]\nhosts = 192.0.2.1\n", "groups:2: unknown option hosts"},
+		{"negative threshold", "[a]\ngroup-dead = -1\nhost = 192.0.2.1\n", "groups:2: invalid group-dead"},
+		{"invalid host", "[a]\nhost = 192.0.2.1:alive=0\n", "groups:2: invalid alive"},
+		{"group without hosts", "[a]\nhost = 192.0.2.1\n[b]\n", "group b has no hosts"},
+		{"invalid initial command", "[a]\ninitial-command = maybe\nhost = 192.0.2.1\n", "groups:2: invalid initial-command"},
+		{"unknown mode", "[a]\ngroup-mode = most\nhost = 192.0.2.1\n", "group a: group mode must be"},
+		{"mode with thresholds", "[a]\ngroup-mode = any\ngroup-alive = 2\nhost = 192.0.2.1\n", "group mode any sets"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "groups")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := readGroupsFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckGroupOverlap(t *testing.T) {
+	host := func(ip string) target { return target{ip: net.ParseIP(ip)} }
+
+	tests := []struct {
+		name    string
+		targets []target
+		groups  []*group
+		wantErr bool
+	}{
+		{
+			name:    "distinct hosts",
+			targets: []target{host("192.0.2.1")},
+			groups:  []*group{{name: "a", targets: []target{host("192.0.2.2")}}},
+		},
+		{
+			name:    "repeated within a group",
+			targets: []target{host("192.0.2.1"), host("192.0.2.1")},
+		},
+		{
+			name:    "command line and a group",
+			targets: []target{host("192.0.2.1")},
+			groups:  []*group{{name: "a", targets: []target{host("192.0.2.1")}}},
+			wantErr: true,
+		},
+		{
+			name: "two groups",
+			groups: []*group{
+				{name: "a", targets: []target{host("2001:db8::1")}},
+				{name: "b", targets: []target{host("2001:db8:0::1")}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGroupOverlap(tt.targets, tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmptyGroupNeverTransitions(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) {
+		p.groupStableRounds = 1
+		p.groups = []*group{{name: "empty"}}
+	}, "192.0.2.1")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++"})
+
+	empty := p.groups[1]
+	if empty.size != 0 || empty.isTotalAlive || empty.firedState != "" {
+		t.Errorf("empty group transitioned: size %d, alive %v, fired %q", empty.size, empty.isTotalAlive, empty.firedState)
+	}
+	if !p.groups[0].isTotalAlive {
+		t.Error("command line group is not alive")
+	}
+}
+
+func TestInitialAssessment(t *testing.T) {
+	tests := []struct {
+		name         string
+		initial      bool
+		patterns     map[string]string
+		wantAssessed bool
+		wantFired    string
+	}{
+		{
+			name:     "not assessed before the counts",
+			initial:  true,
+			patterns: map[string]string{"192.0.2.1": "-", "192.0.2.2": "-"},
+		},
+		{
+			name:         "dead start is silent by default",
+			patterns:     map[string]string{"192.0.2.1": "--", "192.0.2.2": "--"},
+			wantAssessed: true,
+		},
+		{
+			name:         "dead start fires with the initial command",
+			initial:      true,
+			patterns:     map[string]string{"192.0.2.1": "--", "192.0.2.2": "-+"},
+			wantAssessed: true,
+			wantFired:    stateDead,
+		},
+		{
+			name:         "alive start fires once",
+			initial:      true,
+			patterns:     map[string]string{"192.0.2.1": "++", "192.0.2.2": "++"},
+			wantAssessed: true,
+			wantFired:    stateAlive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, func(p *Ping) {
+				p.initialCommand = tt.initial
+				p.cmdCooldown = time.Hour
+			}, "192.0.2.1", "192.0.2.2")
+			runRounds(t, p, conn, tt.patterns)
+
+			g := p.groups[0]
+			if g.assessed != tt.wantAssessed {
+				t.Errorf("assessed = %v, want %v", g.assessed, tt.wantAssessed)
+			}
+			if g.firedState != tt.wantFired {
+				t.Errorf("fired %q, want %q", g.firedState, tt.wantFired)
+			}
+		})
+	}
+}
+
+func TestInitialCommandOverride(t *testing.T) {
+	enabled, disabled := true, false
+	p, conn := newTestPing(t, func(p *Ping) {
+		p.initialCommand = true
+		p.groups = []*group{
+			{name: "quiet", initialCommand: &disabled, targets: []target{{ip: net.ParseIP("192.0.2.2")}}},
+			{name: "loud", initialCommand: &enabled, targets: []target{{ip: net.ParseIP("192.0.2.3")}}},
+		}
+	}, "192.0.2.1")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "--", "192.0.2.2": "--", "192.0.2.3": "--"})
+
+	for i, want := range []string{stateDead, "", stateDead} {
+		if g := p.groups[i]; !g.assessed || g.firedState != want {
+			t.Errorf("%s: assessed %v, fired %q, want %q", g.describe(), g.assessed, g.firedState, want)
+		}
+	}
+}
+
+func TestAllGroupsEmpty(t *testing.T) {
+	named := []*group{{name: "a", targets: []target{{ip: net.ParseIP("192.0.2.1")}}}}
+	tests := []struct {
+		name       string
+		p          *Ping
+		groups     []*group
+		wantGroups int
+	}{
+		{"no hosts", &Ping{}, nil, 0},
+		{"allowed empty", &Ping{allowEmpty: true}, nil, 1},
+		{"control socket", &Ping{controlSocket: "/run/pinger.sock"}, nil, 1},
+		{"named groups", &Ping{allowEmpty: true}, named, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.allGroups(nil, tt.groups); len(got) != tt.wantGroups {
+				t.Errorf("got %d groups, want %d", len(got), tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestWeightedThresholds(t *testing.T) {
+	tests := []struct {
+		name      string
+		patterns  map[string]string
+		wantAlive bool
+		wantUp    int
+	}{
+		{"core alone", map[string]string{"192.0.2.1": "++", "192.0.2.2": "--", "192.0.2.3": "--"}, true, 1},
+		{"edges alone", map[string]string{"192.0.2.1": "--", "192.0.2.2": "++", "192.0.2.3": "++"}, false, 2},
+		{"core lost", map[string]string{"192.0.2.1": "++--", "192.0.2.2": "++++", "192.0.2.3": "++++"}, false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, func(p *Ping) {
+				p.groupAlive = 3
+				p.groupDead = 2
+			}, "192.0.2.1:weight=3", "192.0.2.2", "192.0.2.3")
+			runRounds(t, p, conn, tt.patterns)
+
+			g := p.groups[0]
+			if g.weight != 5 {
+				t.Errorf("group weight %d, want 5", g.weight)
+			}
+			if g.isTotalAlive != tt.wantAlive || g.totalAlive != tt.wantUp {
+				t.Errorf("alive %v with %d hosts up, want %v with %d", g.isTotalAlive, g.totalAlive, tt.wantAlive, tt.wantUp)
+			}
+		})
+	}
+}
+
+func TestGroupMode(t *testing.T) {
+	tests := []struct {
+		mode      string
+		patterns  map[string]string
+		wantAlive bool
+	}{
+		{groupModeAny, map[string]string{"192.0.2.1": "++", "192.0.2.2": "--", "192.0.2.3": "--"}, true},
+		{groupModeAny, map[string]string{"192.0.2.1": "++--", "192.0.2.2": "++--", "192.0.2.3": "++++"}, true},
+		{groupModeAny, map[string]string{"192.0.2.1": "++--", "192.0.2.2": "++--", "192.0.2.3": "++--"}, false},
+		{groupModeAll, map[string]string{"192.0.2.1": "++", "192.0.2.2": "++", "192.0.2.3": "--"}, false},
+		{groupModeAll, map[string]string{"192.0.2.1": "++", "192.0.2.2": "++", "192.0.2.3": "++"}, true},
+		{groupModeAll, map[string]string{"192.0.2.1": "++++", "192.0.2.2": "++++", "192.0.2.3": "++--"}, false},
+	}
+
+	for _, tt := range tests {
+		p, conn := newTestPing(t, func(p *Ping) { p.groupMode = tt.mode }, "192.0.2.1:weight=2", "192.0.2.2", "192.0.2.3")
+		runRounds(t, p, conn, tt.patterns)
+
+		if g := p.groups[0]; g.isTotalAlive != tt.wantAlive {
+			t.Errorf("mode %s with %v: alive %v, want %v", tt.mode, tt.patterns, g.isTotalAlive, tt.wantAlive)
+		}
+	}
+}
+
+func TestDuplicateHosts(t *testing.T) {
+	var targets []target
+	for _, arg := range []string{"192.0.2.0/30", "192.0.2.1", "192.0.2.1=again", "192.0.2.9"} {
+		parsed, err := parseTarget(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, parsed...)
+	}
+	groups := []*group{{name: "a", targets: []target{{ip: net.ParseIP("192.0.2.20")}, {ip: net.ParseIP("192.0.2.20")}}}}
+
+	got := duplicateHosts(targets, groups)
+	if strings.Join(got, " ") != "192.0.2.1 192.0.2.20" {
+		t.Errorf("got duplicates %v, want 192.0.2.1 and 192.0.2.20", got)
+	}
+	if got = duplicateHosts(targets[:2], nil); len(got) != 0 {
+		t.Errorf("got duplicates %v of the distinct hosts", got)
+	}
+}
+
+func TestUnknownInitialState(t *testing.T) {
+	tests := []struct {
+		name         string
+		initialState string
+		flapping     string
+		wantAlive    bool
+		wantUnknown  bool
+	}{
+		{"down flapping", stateDown, "+-+-", false, false},
+		{"unknown flapping", stateUnknown, "+-+-", true, true},
+		{"unknown turned dead", stateUnknown, "+---", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, func(p *Ping) {
+				p.initialState = tt.initialState
+				p.groupAlive = 2
+				p.groupDead = 1
+			}, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+			runRounds(t, p, conn, map[string]string{"192.0.2.1": "++++", "192.0.2.2": "++--", "192.0.2.3": tt.flapping})
+
+			if g := p.groups[0]; g.isTotalAlive != tt.wantAlive {
+				t.Errorf("group alive %v, want %v", g.isTotalAlive, tt.wantAlive)
+			}
+			if ri := p.send["192.0.2.3"]; ri.unknown != tt.wantUnknown {
+				t.Errorf("flapping host unknown %v, want %v", ri.unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
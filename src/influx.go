@@ -0,0 +1,165 @@
+package src
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// influx writes the round results to InfluxDB in the line protocol, all the methods
+// are no-ops on a nil receiver so the callers need not check if it is enabled
+type influx struct {
+	log     *zap.Logger
+	url     string // the write endpoint including the bucket
+	token   string
+	client  *http.Client
+	pending sync.WaitGroup // writes in flight
+}
+
+// newInflux returns the writer of the validated options
+func newInflux(log *zap.Logger, addr, bucket, org, token string, timeout time.Duration) *influx {
+	endpoint, _ := influxWriteURL(addr, bucket, org)
+	return &influx{
+		log:    log,
+		url:    endpoint,
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// influxWriteURL returns the write endpoint of the InfluxDB 2 API,
+// also served by InfluxDB 1.8 taking the database as the bucket
+func influxWriteURL(addr, bucket, org string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("influx url %s must be http or https", addr)
+	}
+	if bucket == "" {
+		return "", errors.New("influx bucket must be given with the url")
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	q := url.Values{"bucket": {bucket}, "precision": {"ns"}}
+	if org != "" {
+		q.Set("org", org)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// writeRound writes a point per host and per group in the background,
+// batching the whole round into a single request
+func (ix *influx) writeRound(hosts map[string]*remoteInfo, groups []*group) {
+	if ix == nil {
+		return
+	}
+
+	body := roundLines(hosts, groups, time.Now())
+	ix.pending.Add(1)
+	go func() {
+		defer ix.pending.Done()
+		if err := ix.post(body); err != nil {
+			ix.log.Error("Failed to write to InfluxDB", zap.Error(err))
+		}
+	}()
+}
+
+func (ix *influx) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, ix.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if ix.token != "" {
+		req.Header.Set("Authorization", "Token "+ix.token)
+	}
+
+	resp, err := ix.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// close waits for the writes in flight
+func (ix *influx) close() {
+	if ix == nil {
+		return
+	}
+	ix.pending.Wait()
+}
+
+// roundLines renders the state of the hosts and the groups in the line protocol:
+//
+//	pinger_host,ip=192.0.2.1,label=core-router up=1i,replied=true,rtt_ms=1.25 1700000000000000000
+//	pinger_group,group=uplinks alive=1i,up_count=2i,hosts=2i 1700000000000000000
+//
+// the rtt is only written for the hosts which replied in the round
+func roundLines(hosts map[string]*remoteInfo, groups []*group, now time.Time) []byte {
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+
+	keys := make([]string, 0, len(hosts))
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for _, key := range keys {
+		ri := hosts[key]
+		b.WriteString("pinger_host")
+		writeTag(&b, "ip", key)
+		writeTag(&b, "name", ri.name)
+		writeTag(&b, "label", ri.label)
+		writeTag(&b, "group", ri.group.name)
+
+		fmt.Fprintf(&b, " up=%di,replied=%t", boolInt(ri.stableIsUp), ri.gotReply)
+		if ri.gotReply && ri.rtt.count > 0 {
+			b.WriteString(",rtt_ms=" + strconv.FormatFloat(float64(ri.rtt.last)/float64(time.Millisecond), 'f', -1, 64))
+		}
+		b.WriteString(" " + ts + "\n")
+	}
+
+	for _, g := range groups {
+		b.WriteString("pinger_group")
+		writeTag(&b, "group", g.name)
+		fmt.Fprintf(&b, " alive=%di,up_count=%di,hosts=%di %s\n", boolInt(g.isTotalAlive), g.totalAlive, g.size, ts)
+	}
+
+	return b.Bytes()
+}
+
+// tagEscaper escapes the characters special in the tag keys and values
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// writeTag appends the tag unless its value is empty, which the line protocol forbids
+func writeTag(b *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString("," + key + "=" + tagEscaper.Replace(value))
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package src
+
+import (
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRoundLines(t *testing.T) {
+	g := &group{name: "up links", size: 2, totalAlive: 1, isTotalAlive: true}
+	replied := &remoteInfo{label: "core,router", group: g, stableIsUp: true, gotReply: true}
+	replied.rtt.add(1250 * time.Microsecond)
+	hosts := map[string]*remoteInfo{
+		"192.0.2.1": replied,
+		"192.0.2.2": {name: "example.com", group: g},
+	}
+
+	got := string(roundLines(hosts, []*group{g, {size: 1}}, time.Unix(1700000000, 0)))
+	want := `pinger_host,ip=192.0.2.1,label=core\,router,group=up\ links up=1i,replied=true,rtt_ms=1.25 1700000000000000000
+pinger_host,ip=192.0.2.2,name=example.com,group=up\ links up=0i,replied=false 1700000000000000000
+pinger_group,group=up\ links alive=1i,up_count=1i,hosts=2i 1700000000000000000
+pinger_group alive=0i,up_count=0i,hosts=1i 1700000000000000000
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInfluxWrite(t *testing.T) {
+	var query, auth, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, auth = r.URL.String(), r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ix := newInflux(zap.NewNop(), server.URL+"/", "pings", "ops", "secret", time.Second)
+	g := &group{size: 1}
+	ix.writeRound(map[string]*remoteInfo{"192.0.2.1": {group: g}}, []*group{g})
+	ix.close()
+
+	if query != "/api/v2/write?bucket=pings&org=ops&precision=ns" {
+		t.Errorf("got query %s", query)
+	}
+	if auth != "Token secret" {
+		t.Errorf("got authorization %q", auth)
+	}
+	if body == "" {
+		t.Error("got no points")
+	}
+}
+
+func TestInfluxWriteURL(t *testing.T) {
+	for _, tt := range []struct{ addr, bucket string }{
+		{"localhost:8086", "pings"},
+		{"udp://localhost:8086", "pings"},
+		{"http://localhost:8086", ""},
+	} {
+		if _, err := influxWriteURL(tt.addr, tt.bucket, ""); err == nil {
+			t.Errorf("accepted %s with bucket %q", tt.addr, tt.bucket)
+		}
+	}
+}
@@ -1,11 +1,35 @@
 package src
 
 import (
+	"fmt"
 	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"os"
+	"strings"
 )
 
-func createLogger(verbose bool) *zap.Logger {
+type logOptions struct {
+	verbose    bool   // enable debug logging
+	level      string // debug, info, warn or error
+	format     string // console or json
+	syslog     bool   // log to syslog instead of stderr
+	syslogAddr string // remote syslog address, empty for the local daemon
+	file       string // log to the rotated file instead of stderr
+	maxSize    int    // size in megabytes to rotate the file at
+	maxBackups int    // number of the rotated files to keep
+	color      string // colorize the console on stderr, auto, always or never
+}
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// createLogger builds the logger and returns it with its level adjustable at runtime
+func createLogger(opts logOptions) (*zap.Logger, zap.AtomicLevel, error) {
 	cfg := zap.Config{
 		Encoding:    "console",
 		OutputPaths: []string{"stderr"},
@@ -13,19 +37,155 @@ func createLogger(verbose bool) *zap.Logger {
 			MessageKey:       "message",
 			LevelKey:         "level",
 			EncodeLevel:      zapcore.CapitalLevelEncoder,
+			EncodeDuration:   zapcore.StringDurationEncoder,
 			ConsoleSeparator: "  ",
 		},
-		Level: zap.NewAtomicLevelAt(zap.DebugLevel),
 	}
 
-	if !verbose {
-		cfg.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	level, err := parseLogLevel(opts.level)
+	if err != nil {
+		return nil, zap.AtomicLevel{}, err
+	}
+	cfg.Level = zap.NewAtomicLevelAt(level)
+
+	if opts.format == "json" {
+		cfg.Encoding = "json"
+		cfg.EncoderConfig.TimeKey = "time"
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	}
+
+	if opts.syslog {
+		// syslog records the time and the severity itself
+		cfg.EncoderConfig.TimeKey = ""
+		cfg.EncoderConfig.LevelKey = ""
+
+		core, err := newSyslogCore(opts.syslogAddr, newEncoder(cfg), cfg.Level)
+		if err != nil {
+			return nil, cfg.Level, err
+		}
+		return zap.New(core), cfg.Level, nil
+	}
+
+	if opts.file != "" {
+		// unlike stderr a file has no one else to timestamp the lines
+		cfg.EncoderConfig.TimeKey = "time"
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+		writer := &lumberjack.Logger{
+			Filename:   opts.file,
+			MaxSize:    opts.maxSize,
+			MaxBackups: opts.maxBackups,
+		}
+		return zap.New(zapcore.NewCore(newEncoder(cfg), zapcore.AddSync(writer), cfg.Level)), cfg.Level, nil
+	}
+
+	if opts.format == "console" && useColor(opts.color, os.Stderr) {
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		core := zapcore.NewCore(colorEncoder{newEncoder(cfg)}, zapcore.Lock(os.Stderr), cfg.Level)
+		return zap.New(core, zap.ErrorOutput(zapcore.Lock(os.Stderr))), cfg.Level, nil
 	}
 
 	logger, err := cfg.Build()
-	if err != nil {
-		panic(err)
+	return logger, cfg.Level, err
+}
+
+// parseLogLevel returns the level of the name, the empty one being info
+func parseLogLevel(name string) (zapcore.Level, error) {
+	switch name {
+	case "":
+		return zap.InfoLevel, nil
+	case "debug", "info", "warn", "error":
+		return zapcore.ParseLevel(name)
+	}
+	return zap.InfoLevel, fmt.Errorf("log level must be debug, info, warn or error, not %s", name)
+}
+
+// useColor returns whether to colorize the output to the file, in the auto mode
+// if it is a terminal and NO_COLOR is not set
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
 	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	colorGreen = "\x1b[32m"
+	colorRed   = "\x1b[31m"
+	colorReset = "\x1b[0m"
+)
+
+// colorEncoder paints the messages of the alive states green and of the dead ones red
+type colorEncoder struct {
+	zapcore.Encoder
+}
+
+func (e colorEncoder) Clone() zapcore.Encoder {
+	return colorEncoder{e.Encoder.Clone()}
+}
+
+func (e colorEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if color := stateColor(entry.Message, fields); color != "" {
+		entry.Message = color + entry.Message + colorReset
+	}
+	return e.Encoder.EncodeEntry(entry, fields)
+}
 
-	return logger
+// stateColor returns the color of the state given by the state field or named by the message
+func stateColor(message string, fields []zapcore.Field) string {
+	state := ""
+	for _, f := range fields {
+		if f.Key == "state" && f.Type == zapcore.StringType {
+			state = f.String
+		}
+	}
+	if state == "" {
+		switch {
+		case strings.HasSuffix(message, " alive") || strings.Contains(message, " alive "):
+			state = stateAlive
+		case strings.HasSuffix(message, " dead") || strings.Contains(message, " dead "):
+			state = stateDead
+		}
+	}
+
+	switch state {
+	case stateAlive:
+		return colorGreen
+	case stateDead:
+		return colorRed
+	}
+	return ""
+}
+
+// toggleLevelLoop switches the log level between debug and the configured one on each signal
+// until done, from the configured debug to info
+func (p *Ping) toggleLevelLoop(toggle chan os.Signal, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-toggle:
+			level := zap.DebugLevel
+			if p.logLevel.Enabled(zap.DebugLevel) {
+				level = max(p.logBase, zap.InfoLevel)
+			}
+			p.logLevel.SetLevel(level)
+			p.log.Info("Switched the log level", zap.Stringer("level", level))
+		}
+	}
+}
+
+// newEncoder returns the encoder of the configured format
+func newEncoder(cfg zap.Config) zapcore.Encoder {
+	if cfg.Encoding == "json" {
+		return zapcore.NewJSONEncoder(cfg.EncoderConfig)
+	}
+	return zapcore.NewConsoleEncoder(cfg.EncoderConfig)
 }
@@ -0,0 +1,61 @@
+package src
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStateColor(t *testing.T) {
+	for _, tt := range []struct {
+		message string
+		fields  []zapcore.Field
+		want    string
+	}{
+		{"Remote host is alive", nil, colorGreen},
+		{"Transitioning to dead state", nil, colorRed},
+		{"Assessed the initial state", []zapcore.Field{zap.String("state", stateDead)}, colorRed},
+		{"Reloading the config", nil, ""},
+		{"Watching group", []zapcore.Field{zap.Int("hosts", 2)}, ""},
+	} {
+		if got := stateColor(tt.message, tt.fields); got != tt.want {
+			t.Errorf("%s: got color %q, want %q", tt.message, got, tt.want)
+		}
+	}
+
+	enc := colorEncoder{zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "message"})}
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "Remote host is dead"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, colorRed+"Remote host is dead"+colorReset) {
+		t.Errorf("got line %q", got)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if useColor(colorAuto, f) || useColor(colorNever, f) || !useColor(colorAlways, f) {
+		t.Error("colorized a file in the auto mode or missed the explicit mode")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, want := range map[string]zapcore.Level{"": zap.InfoLevel, "debug": zap.DebugLevel, "warn": zap.WarnLevel, "error": zap.ErrorLevel} {
+		if got, err := parseLogLevel(name); err != nil || got != want {
+			t.Errorf("%q: got %v, %v, want %v", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"trace", "fatal", "WARNING"} {
+		if _, err := parseLogLevel(name); err == nil {
+			t.Errorf("%q: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	pushJob     = "net-pinger"
+	pushTimeout = 10 * time.Second
+)
+
+// metrics exports the ping results to Prometheus, served for scraping and/or
+// pushed to a pushgateway on exit, all the methods are no-ops on a nil receiver
+// so the callers need not check if it is enabled
+type metrics struct {
+	log      *zap.Logger
+	server   *http.Server // nil unless scraped
+	registry *prometheus.Registry
+	pushURL  string // pushgateway to push the final values to, empty to disable
+	hostUp   *prometheus.GaugeVec
+	degraded *prometheus.GaugeVec
+	sent     *prometheus.CounterVec
+	received *prometheus.CounterVec
+	rtt      *prometheus.HistogramVec
+	commands *prometheus.CounterVec
+}
+
+func newMetrics(log *zap.Logger, addr, pushURL string) *metrics {
+	m := &metrics{
+		log:     log,
+		pushURL: pushURL,
+		hostUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pinger_host_up",
+			Help: "Whether the remote host is considered alive.",
+		}, []string{"ip", "label"}),
+		degraded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pinger_host_degraded",
+			Help: "Whether the remote host is alive but replying slower than the rtt threshold.",
+		}, []string{"ip", "label"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pinger_sent_packets_total",
+			Help: "Number of echo requests sent.",
+		}, []string{"ip", "label"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pinger_received_packets_total",
+			Help: "Number of echo replies received.",
+		}, []string{"ip", "label"}),
+		rtt: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pinger_rtt_seconds",
+			Help:    "Round-trip time of the echo replies.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"ip", "label"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pinger_command_total",
+			Help: "Number of commands run by the state they were run on and whether they succeeded.",
+		}, []string{"state", "result"}),
+	}
+
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(m.hostUp, m.degraded, m.sent, m.received, m.rtt, m.commands)
+
+	if addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+		m.server = &http.Server{Addr: addr, Handler: mux}
+	}
+
+	return m
+}
+
+func (m *metrics) start() {
+	if m == nil || m.server == nil {
+		return
+	}
+
+	go func() {
+		m.log.Info("Serving metrics", zap.String("addr", m.server.Addr))
+		if err := m.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.log.Error("Metrics server failed", zap.Error(err))
+		}
+	}()
+}
+
+// close pushes the final values and stops serving them
+func (m *metrics) close() {
+	if m == nil {
+		return
+	}
+
+	if m.pushURL != "" {
+		m.push()
+	}
+
+	if m.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = m.server.Shutdown(ctx)
+	}
+}
+
+// push replaces the metrics of the job and the instance on the pushgateway
+func (m *metrics) push() {
+	pusher := push.New(m.pushURL, pushJob).
+		Gatherer(m.registry).
+		Client(&http.Client{Timeout: pushTimeout})
+	if instance, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", instance)
+	}
+
+	if err := pusher.Push(); err != nil {
+		m.log.Error("Failed to push the metrics", zap.String("url", m.pushURL), zap.Error(err))
+		return
+	}
+	m.log.Info("Pushed the metrics", zap.String("url", m.pushURL))
+}
+
+func (m *metrics) setUp(ri *remoteInfo) {
+	if m == nil {
+		return
+	}
+
+	m.hostUp.WithLabelValues(ri.address(), ri.label).Set(float64(boolInt(ri.stableIsUp)))
+	m.degraded.WithLabelValues(ri.address(), ri.label).Set(float64(boolInt(ri.degraded)))
+}
+
+func (m *metrics) packetSent(ri *remoteInfo) {
+	if m == nil {
+		return
+	}
+	m.sent.WithLabelValues(ri.address(), ri.label).Inc()
+}
+
+func (m *metrics) packetReceived(ri *remoteInfo, rtt time.Duration, hasRTT bool) {
+	if m == nil {
+		return
+	}
+
+	m.received.WithLabelValues(ri.address(), ri.label).Inc()
+	if hasRTT {
+		m.rtt.WithLabelValues(ri.address(), ri.label).Observe(rtt.Seconds())
+	}
+}
+
+// commandRun counts the command run on the state, failed on an error or the timeout
+func (m *metrics) commandRun(state string, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.commands.WithLabelValues(state, result).Inc()
+}
+
+// forget drops the series of the host, e.g. when its address changes
+func (m *metrics) forget(ri *remoteInfo) {
+	if m == nil {
+		return
+	}
+
+	ip := ri.address()
+	m.hostUp.DeleteLabelValues(ip, ri.label)
+	m.degraded.DeleteLabelValues(ip, ri.label)
+	m.sent.DeleteLabelValues(ip, ri.label)
+	m.received.DeleteLabelValues(ip, ri.label)
+	m.rtt.DeleteLabelValues(ip, ri.label)
+}
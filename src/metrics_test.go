@@ -0,0 +1,36 @@
+package src
+
+import (
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsPush(t *testing.T) {
+	var method, path string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	m := newMetrics(zap.NewNop(), "", server.URL)
+	if m.server != nil {
+		t.Error("metrics are served without an address")
+	}
+	m.setUp(&remoteInfo{ip: net.ParseIP("192.0.2.1"), label: "core", stableIsUp: true})
+	m.start()
+	m.close()
+
+	if method != http.MethodPut || !strings.HasPrefix(path, "/metrics/job/"+pushJob) {
+		t.Errorf("got %s %s, want PUT of the job", method, path)
+	}
+	if !strings.Contains(string(body), "pinger_host_up") {
+		t.Error("the pushed metrics lack pinger_host_up")
+	}
+}
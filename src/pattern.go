@@ -0,0 +1,66 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pattern fills the echo data following the timestamp, given on the command
+// line as a byte in hex, e.g. 0x55, or as inc for the bytes counting up from 0
+type pattern struct {
+	inc  bool
+	fill byte
+}
+
+func (v *pattern) String() string {
+	if v.inc {
+		return "inc"
+	}
+	return fmt.Sprintf("0x%02x", v.fill)
+}
+
+func (v *pattern) Set(s string) error {
+	if s == "inc" {
+		*v = pattern{inc: true}
+		return nil
+	}
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 8)
+	if err != nil {
+		return errors.New("must be a byte in hex or inc")
+	}
+	*v = pattern{fill: byte(n)}
+	return nil
+}
+
+func (v *pattern) Type() string {
+	return "pattern"
+}
+
+// at returns the byte of the pattern at the offset
+func (v pattern) at(i int) byte {
+	if v.inc {
+		return byte(i)
+	}
+	return v.fill
+}
+
+// apply fills the data with the pattern
+func (v pattern) apply(data []byte) {
+	for i := range data {
+		data[i] = v.at(i)
+	}
+}
+
+// mismatch returns the offset of the first byte of the data differing
+// from the pattern, -1 if they match
+func (v pattern) mismatch(data []byte) int {
+	for i, b := range data {
+		if b != v.at(i) {
+			return i
+		}
+	}
+	return -1
+}
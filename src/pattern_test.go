@@ -0,0 +1,38 @@
+package src
+
+import (
+	"testing"
+)
+
+func TestPattern(t *testing.T) {
+	for _, tt := range []struct {
+		arg  string
+		want string
+	}{
+		{"0x55", "0x55"},
+		{"aa", "0xaa"},
+		{"0XFF", "0xff"},
+		{"inc", "inc"},
+	} {
+		var v pattern
+		if err := v.Set(tt.arg); err != nil || v.String() != tt.want {
+			t.Errorf("%s: got %s, %v, want %s", tt.arg, v.String(), err, tt.want)
+		}
+	}
+
+	var v pattern
+	if v.Set("0x100") == nil || v.Set("dec") == nil {
+		t.Error("accepted an invalid pattern")
+	}
+
+	data := make([]byte, 300)
+	v = pattern{inc: true}
+	v.apply(data)
+	if data[1] != 1 || data[256] != 0 || v.mismatch(data) != -1 {
+		t.Errorf("bad counting bytes %v", data[:4])
+	}
+	data[42]++
+	if got := v.mismatch(data); got != 42 {
+		t.Errorf("mismatch at %d, want 42", got)
+	}
+}
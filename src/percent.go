@@ -0,0 +1,40 @@
+package src
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// percent is a share given on the command line as 10% or 10
+type percent float64
+
+func (v *percent) String() string {
+	return strconv.FormatFloat(float64(*v)*100, 'f', -1, 64) + "%"
+}
+
+func (v *percent) Set(s string) error {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("must not be negative")
+	}
+	*v = percent(n / 100)
+	return nil
+}
+
+func (v *percent) Type() string {
+	return "percent"
+}
+
+// apply returns the duration randomly deviated by up to the share either way
+func (v percent) apply(d time.Duration) time.Duration {
+	if v == 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*float64(v)*float64(d))
+}
@@ -0,0 +1,37 @@
+package src
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercent(t *testing.T) {
+	for _, tt := range []struct {
+		arg  string
+		want percent
+	}{
+		{"10%", 0.1},
+		{"25", 0.25},
+		{"0%", 0},
+	} {
+		var v percent
+		if err := v.Set(tt.arg); err != nil || v != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.arg, v, err, tt.want)
+		}
+	}
+
+	var v percent
+	if v.Set("-5%") == nil || v.Set("ten") == nil {
+		t.Error("accepted an invalid share")
+	}
+
+	v = 0.1
+	for range 100 {
+		if d := v.apply(time.Second); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatalf("deviated to %s", d)
+		}
+	}
+	if d := percent(0).apply(time.Second); d != time.Second {
+		t.Errorf("deviated without jitter to %s", d)
+	}
+}
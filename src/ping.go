@@ -1,128 +1,1113 @@
 package src
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/spf13/pflag"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/time/rate"
 	"net"
 	"os"
-	"os/exec"
+	"os/signal"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type remoteInfo struct {
 	ip           net.IP
+	zone         string // IPv6 scope of the link-local addresses
+	name         string
+	ptr          string // name of the numeric host from the reverse lookup, for the logs only
+	label        string // friendly name given by the user
 	addr         net.Addr
+	conn         *icmpConn
 	isUp         bool
 	stableIsUp   bool
 	pingsInState int
 	gotReply     bool
+	replied      uint16        // probes of the round replied to, a bit per offset of their seq
+	path         int           // number of the pings sent over the sockets of the ECMP mode
+	sendFailed   bool          // whether the ping of the current round failed to be sent
+	skipped      bool          // whether the host was not due for a ping in the current round
+	lastPing     time.Duration // monotonic time the host was last pinged at
+	lastChange   time.Time     // when the confirmed state began, the start for the initial one
+	pause        time.Duration // delay between the pings of the host, 0 for the global pause
+	seqOffset    uint16        // first sequence number of the host past the one of the round, in the match-id-only mode
+	window       *resultWindow // results of the last pings in the sliding window mode, nil for the consecutive counts
+	group        *group
+	counted      bool  // whether the host is counted in the group totalAlive
+	aliveCount   uint8 // number of alive pings to consider host alive
+	deadCount    uint8 // number of dead pings to consider host dead
+	weight       int   // weight of the host in the group thresholds
+	rtt          rttStats
+	slow         bool // whether the last reply exceeded the rtt threshold
+	slowInState  int  // number of consecutive replies on the same side of the threshold
+	degraded     bool // whether the host is alive but too slow
+	unknown      bool // whether the host is neither alive nor dead yet, ignored by the group thresholds
+	broadcast    bool // whether the host is a broadcast or multicast address replied to by the others
+	sent         int  // number of pings sent
+	received     int  // number of replies received
+}
+
+// String returns the host in a human-readable form for logging
+func (ri *remoteInfo) String() string {
+	if ri.label != "" {
+		return fmt.Sprintf("%s (%s)", ri.label, ri.address())
+	}
+	if ri.name != "" {
+		return fmt.Sprintf("%s (%s)", ri.name, ri.address())
+	}
+	if ri.ptr != "" {
+		return fmt.Sprintf("%s (%s)", ri.ptr, ri.address())
+	}
+	return ri.address()
+}
+
+// changeState records the change of the confirmed state, returns how long the previous one lasted
+// rounded for the logs
+func (ri *remoteInfo) changeState() time.Duration {
+	now := time.Now()
+	lasted := now.Sub(ri.lastChange).Round(time.Second)
+	ri.lastChange = now
+	return lasted
+}
+
+// address returns the ip with the zone, if any, e.g. fe80::1%eth0, the key of the host
+func (ri *remoteInfo) address() string {
+	return zonedAddress(ri.ip, ri.zone)
 }
 
 type Ping struct {
-	log           *zap.Logger   // logger
-	ips           []net.IP      // the ip list to ping
-	waitTimeout   time.Duration // a single ping wait deadline
-	pauseDuration time.Duration // delay between pings
-	aliveCount    uint8         // number of alive pings to consider host alive
-	deadCount     uint8         // number of dead pings to consider host dead
-	groupAlive    uint8         // number of alive hosts to consider whole setup alive
-	groupDead     uint8         // number of alive hosts fo consider whole setup dead
-	cmdAlive      string        // command to run when Alive
-	cmdDead       string        // command to run when Dead
-
-	conn         *icmp.PacketConn
-	send         map[string]*remoteInfo
-	pid          uint16
-	seq          uint16
-	totalAlive   int
-	isTotalAlive bool
+	log               *zap.Logger   // logger
+	targets           []target      // the ip list to ping
+	waitTimeout       time.Duration // a single ping wait deadline
+	pauseDuration     time.Duration // delay between pings
+	minPause          time.Duration // delay between pings of the flapping hosts, 0 to disable
+	jitter            percent       // random deviation of the pause
+	aliveCount        uint8         // number of alive pings to consider host alive
+	deadCount         uint8         // number of dead pings to consider host dead
+	window            int           // number of the last pings the counts are taken from, 0 for the consecutive ones
+	initialState      string        // state of the new hosts, down or unknown
+	groupAlive        int           // number of alive hosts to consider whole setup alive
+	groupDead         int           // number of alive hosts fo consider whole setup dead
+	groupMode         string        // any or all setting the group thresholds from the weight, or count
+	groupStableRounds int           // rounds a group stays past its threshold before transitioning
+	cmdAlive          []string      // commands to run in order when Alive
+	cmdDead           []string      // commands to run in order when Dead
+	groups            []*group      // the groups configured in addition to the command line one
+	configFile        string        // YAML config file
+	targetLists       []string      // comma-separated lists of the hosts to ping
+	targetsFile       string        // file with the hosts to ping
+	groupsFile        string        // file with the additional groups
+	strict            bool          // refuse the duplicate hosts instead of warning
+	cmdHostAlive      string        // command to run when a single host is Alive
+	cmdHostDead       string        // command to run when a single host is Dead
+	cmdDegraded       string        // command to run when a single host is Degraded
+	rttThreshold      time.Duration // rtt above which a host is slow, 0 to disable
+	degradedCount     uint8         // number of slow replies to consider host degraded
+	cmdTimeout        time.Duration // deadline after which a command is killed
+	initialCommand    bool          // run the command of the initial group state once assessed
+	cmdCooldown       time.Duration // minimal interval between the transition commands of a group
+	aliveWebhook      string        // URL to POST to when Alive
+	deadWebhook       string        // URL to POST to when Dead
+	cmdHeartbeat      string        // command to run periodically while the loop runs
+	heartbeatEvery    time.Duration // interval of the heartbeat command
+	resolvePTR        bool          // look up the names of the numeric hosts for the logs
+	resolveEvery      time.Duration // hostname re-resolution interval, 0 to disable
+	resolveMinEvery   time.Duration // minimal interval between the re-resolutions of a name
+	metricsAddr       string        // address to serve Prometheus metrics on
+	pushgatewayURL    string        // Prometheus pushgateway to push the metrics to on exit
+	statusAddr        string        // address to serve the JSON status on
+	influxURL         string        // InfluxDB to write the round results to
+	influxBucket      string        // bucket of the InfluxDB to write to
+	influxOrg         string        // organization of the InfluxDB bucket
+	influxToken       string        // API token of the InfluxDB
+	statsdAddr        string        // StatsD agent to emit the round results to
+	csvFile           string        // file to append the round results to as CSV
+	stateFile         string        // file to keep the host and group state in across restarts
+	controlSocket     string        // unix socket to accept the control commands on
+	allowEmpty        bool          // start without hosts, adding them at runtime
+	writeEvents       bool          // write the transitions to stdout
+	probesPerRound    int           // number of requests sent to each host per round
+	sendRate          float64       // maximum number of requests sent per second, 0 for unlimited
+	sendConcurrency   int           // number of requests sent in parallel
+	payloadSize       int           // size of the echo data
+	payloadPattern    pattern       // fills the echo data after the timestamp
+	recvBuffer        int           // size of the receive buffer, 0 to fit the payload
+	ttl               int           // time to live of the outgoing packets, 0 for system default
+	tos               int           // type of service of the outgoing packets
+	dscp              int           // DSCP of the outgoing packets, an alternative to tos
+	iface             string        // interface to send the packets from
+	source            net.IP        // address to send the packets from
+	count             int           // number of rounds to run, 0 for infinite
+	startupTimeout    time.Duration // time for the groups to become alive before Run fails, 0 to wait forever
+	exitCode          bool          // exit with the status reflecting the final group state
+	roundSummary      bool          // log a line per round as a heartbeat
+	showConfig        bool          // log the effective config at info instead of debug
+	raw               bool          // use raw ICMP sockets
+	partialStack      bool          // skip the hosts of an address family whose socket fails to open
+	retryBind         int           // number of the retries of opening a socket before giving up
+	ecmpProbe         int           // number of the sockets per address family rotated over the rounds, 0 for one
+	icmpID            int           // identifier of the echo requests, 0 for the process id
+	dontFragment      bool          // set the don't fragment bit
+	allowBroadcast    bool          // allow the broadcast and multicast hosts
+	matchIDOnly       bool          // attribute the replies by the sequence numbers, whatever their source
+	tcpPort           int           // probe the hosts by connecting to the TCP port instead of ICMP, 0 to disable
+	httpURL           string        // probe the hosts by requesting the URL instead of ICMP
+	httpExpect        int           // expected HTTP status, 0 for any 2xx
+
+	epoch     time.Time       // reference point of the monotonic timestamps
+	logLevel  zap.AtomicLevel // level of log, toggled by the signal
+	logBase   zapcore.Level   // configured level of log the signal toggles back to
+	options   []zap.Field     // the resolved options, for the config logged at the start
+	metrics   *metrics
+	status    *statusServer
+	influx    *influx
+	statsd    *statsd
+	csv       *csvLog
+	control   *controlServer
+	events    *eventStream
+	limiter   *rate.Limiter // paces the requests, nil if unlimited
+	conn4     *icmpConn
+	conn6     *icmpConn
+	ecmp4     []*icmpConn    // the sockets besides conn4 rotated over the rounds in the ECMP mode
+	ecmp6     []*icmpConn    // the sockets besides conn6 rotated over the rounds in the ECMP mode
+	mu        sync.Mutex     // guards send, groups and paused
+	commands  sync.WaitGroup // queued and running commands, posting webhooks
+	cmdQueue  chan queuedCommand
+	receivers sync.WaitGroup // goroutines reading the sockets
+	send      map[string]*remoteInfo
+	seq       uint16
+	seqHosts  []*remoteInfo // hosts of the round in the order of their sequence numbers, in the match-id-only mode
+	paused    bool          // pinging suspended over the control socket
+	roundAt   time.Duration // monotonic time the current round was sent at
+	tick      time.Duration // pause between the rounds, the shortest one of the hosts
+	lastBeat  time.Time     // when the heartbeat command was last run
+	replies   chan icmpInfo // the replies of the sockets and the probes
+	stopped   chan struct{} // closed on shutdown, releases the replies nobody waits for
+	failed    chan error    // the receivers giving up on a broken socket
+	fatal     error         // the failure which stopped Run
+
+	ptrCache map[string]string // names of the ips by the reverse lookup, empty if none
+
+	resolvedAt map[string]time.Time // when the hostnames were last re-resolved
+	resolveNow chan string          // the hostnames of the dead hosts to re-resolve early
+
+	commandLine bool // the options come from the command line, which is re-read on SIGHUP
 }
 
+// ErrNeverAlive is returned by Run in count mode if the group has never become alive
+var ErrNeverAlive = errors.New("the group has never become alive")
+
+// ErrStartupTimeout is returned by Run if the group has not become alive within the startup timeout
+var ErrStartupTimeout = errors.New("the group has not become alive within the startup timeout")
+
 func NewPingFromCommandLine() (*Ping, error) {
 	p := &Ping{}
-	verbose := p.readArguments()
+	log, level, err := createLogger(p.readArguments())
+	if err != nil {
+		return nil, err
+	}
+	p.log = log
+	p.logLevel = level
+	p.logBase = level.Level()
+	p.commandLine = true
+
+	return p.open()
+}
+
+// open opens the sockets of the configured pinger and completes it
+func (p *Ping) open() (*Ping, error) {
+	if p.probeMode() {
+		return newPing(p, p.log, nil, nil), nil
+	}
 
-	var err error
-	p.log = createLogger(verbose)
-	if p.conn, err = icmp.ListenPacket("udp4", "0.0.0.0"); err != nil {
+	listen := p.retryListen(func(network string) (packetConn, error) {
+		return p.listen(network)
+	})
+	conn4, conn6, err := p.openSockets(listen)
+	if err != nil {
 		return nil, err
 	}
 
-	// linux assigns local "port" to the id of the packets, need to account for that
-	if runtime.GOOS == "linux" {
-		addr := p.conn.IPv4PacketConn().LocalAddr().(*net.UDPAddr)
-		p.pid = uint16(addr.Port)
-	} else {
-		p.pid = uint16(os.Getpid())
+	var extra4, extra6 []packetConn
+	if extra4, err = p.openECMP(listen, "udp4", conn4 != nil); err == nil {
+		extra6, err = p.openECMP(listen, "udp6", conn6 != nil)
+	}
+	if err != nil {
+		for _, c := range slices.Concat([]packetConn{conn4, conn6}, extra4) {
+			if c != nil {
+				_ = c.Close()
+			}
+		}
+		return nil, err
+	}
+
+	p = newPing(p, p.log, conn4, conn6)
+	for _, c := range extra4 {
+		p.ecmp4 = append(p.ecmp4, newICMP4Conn(c, p.conn4.pid+uint16(len(p.ecmp4)+1)))
+	}
+	for _, c := range extra6 {
+		p.ecmp6 = append(p.ecmp6, newICMP6Conn(c, p.conn6.pid+uint16(len(p.ecmp6)+1)))
+	}
+	return p, nil
+}
+
+// openECMP opens the sockets of the family rotated over the rounds besides the first one
+// in the ECMP mode. Each has its own identifier, which the routers hashing the ICMP flows
+// by it, the same as by the ports, spread over the paths.
+func (p *Ping) openECMP(listen func(network string) (packetConn, error), network string, needed bool) ([]packetConn, error) {
+	if !needed {
+		return nil, nil
+	}
+
+	var conns []packetConn
+	for range p.ecmpProbe - 1 {
+		c, err := listen(network)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
+
+// ecmpConn returns the socket of the family of the host to ping it from next in the ECMP mode,
+// each host goes over them in turn
+func (p *Ping) ecmpConn(ri *remoteInfo) *icmpConn {
+	first, rest := p.conn4, p.ecmp4
+	if ri.ip.To4() == nil {
+		first, rest = p.conn6, p.ecmp6
+	}
+
+	turn := ri.path % (len(rest) + 1)
+	ri.path++
+	if turn == 0 {
+		return first
+	}
+	return rest[turn-1]
+}
+
+// conns returns the open sockets, the ones of the ECMP mode included
+func (p *Ping) conns() []*icmpConn {
+	var conns []*icmpConn
+	for _, c := range slices.Concat([]*icmpConn{p.conn4, p.conn6}, p.ecmp4, p.ecmp6) {
+		if c != nil {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// openSockets opens the sockets of the address families of the hosts, conn4 or conn6 is nil
+// if not needed. With the partial stack the hosts of a family whose socket fails to open are
+// skipped, as long as there are hosts of the other one.
+func (p *Ping) openSockets(listen func(network string) (packetConn, error)) (conn4, conn6 packetConn, err error) {
+	if conn4, err = listen("udp4"); err != nil {
+		if !p.partialStack || !p.hasFamily(true) {
+			return nil, nil, err
+		}
+		p.log.Warn("Failed to open the IPv4 socket, skipping the IPv4 hosts", zap.Error(err))
+		conn4 = nil
+	}
+
+	if p.hasFamily(true) {
+		if conn6, err = listen("udp6"); err != nil {
+			if !p.partialStack || conn4 == nil || !p.hasFamily(false) {
+				if conn4 != nil {
+					_ = conn4.Close()
+				}
+				return nil, nil, err
+			}
+			p.log.Warn("Failed to open the IPv6 socket, skipping the IPv6 hosts", zap.Error(err))
+			conn6 = nil
+		}
+	} else if p.controlSocket != "" {
+		// the IPv6 hosts may be added at runtime, but are not required
+		if conn6, err = listen("udp6"); err != nil {
+			p.log.Warn("Failed to open the IPv6 socket, only IPv4 hosts may be added", zap.Error(err))
+			conn6 = nil
+		}
+	}
+
+	return conn4, conn6, nil
+}
+
+// listen opens an ICMP socket and applies the socket options
+func (p *Ping) listen(network string) (*icmp.PacketConn, error) {
+	address, err := p.localAddress(network)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := p.openSocket(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, unprivileged := conn.LocalAddr().(*net.UDPAddr); unprivileged {
+		if err = enableRecvErr(conn); err != nil {
+			p.log.Debug("ICMP errors are not reported", zap.Error(err))
+		}
+		if p.icmpID != 0 && runtime.GOOS == "linux" {
+			p.log.Warn("The kernel assigns the identifier of the unprivileged sockets, ignoring the icmp id",
+				zap.String("network", network))
+		}
+	}
+
+	// the address binding only selects the source, the device binding
+	// also forces the route, where it is not available the former remains
+	if p.iface != "" {
+		if err = bindToDevice(conn, p.iface); err != nil {
+			p.log.Warn("Failed to bind to the interface device, relying on its address",
+				zap.String("interface", p.iface),
+				zap.Error(err))
+		}
+	}
+
+	if p.ttl > 0 {
+		if err = setTTL(conn, p.ttl); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.dontFragment {
+		if err = setDontFragment(conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.allowBroadcast && network == "udp4" {
+		if err = enableBroadcast(conn); err != nil {
+			p.log.Warn("Failed to allow sending to the broadcast addresses", zap.Error(err))
+		}
+	}
+
+	tos := p.tos
+	if p.dscp > 0 {
+		tos = p.dscp << 2
+	}
+	if tos > 0 {
+		if err = setTOS(conn, tos); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// newPing completes the configured pinger with the logger and the connections,
+// conn6 may be nil if there are no IPv6 targets. Taking the connections from
+// the outside allows running the pinger on top of fake ones.
+func newPing(p *Ping, log *zap.Logger, conn4, conn6 packetConn) *Ping {
+	p.epoch = time.Now()
+	p.log = log
+	if conn4 != nil {
+		p.conn4 = newICMP4Conn(conn4, uint16(p.icmpID))
+	}
+	if conn6 != nil {
+		p.conn6 = newICMP6Conn(conn6, uint16(p.icmpID))
+	}
+
+	p.warnDuplicates(p.targets, p.groups)
+	p.groups = p.allGroups(p.targets, p.groups)
+
+	if p.sendRate > 0 {
+		p.limiter = rate.NewLimiter(rate.Limit(p.sendRate), 1)
 	}
 
 	p.send = make(map[string]*remoteInfo)
-	for _, ip := range p.ips {
-		addr := &net.UDPAddr{IP: ip}
-		p.send[ip.String()] = &remoteInfo{
-			ip:           ip,
-			addr:         addr,
-			isUp:         false,
-			pingsInState: 0,
+	p.ptrCache = make(map[string]string)
+	p.resolvedAt = make(map[string]time.Time)
+	p.resolveNow = make(chan string, 16)
+	p.cmdQueue = make(chan queuedCommand, commandQueueSize)
+	go p.commandLoop()
+	for _, g := range p.groups {
+		for _, t := range g.targets {
+			p.addTarget(g, t)
 		}
 	}
+	p.updateGroups()
+	p.restoreState()
 
-	if p.groupAlive == 0 {
-		p.groupAlive = uint8(len(p.ips))
+	if p.metricsAddr != "" || p.pushgatewayURL != "" {
+		p.metrics = newMetrics(p.log, p.metricsAddr, p.pushgatewayURL)
+		for _, ri := range p.send {
+			p.metrics.setUp(ri)
+		}
 	}
 
-	p.log.Info("Starting the pinger",
-		zap.Uint8("active on", p.groupAlive),
-		zap.Uint8("dead on", p.groupDead))
+	if p.statusAddr != "" {
+		p.status = newStatusServer(p, p.statusAddr)
+	}
 
-	return p, nil
+	if p.influxURL != "" {
+		p.influx = newInflux(p.log, p.influxURL, p.influxBucket, p.influxOrg, p.influxToken, p.pauseDuration)
+	}
+
+	if p.statsdAddr != "" {
+		p.statsd = newStatsd(p.log, p.statsdAddr)
+	}
+
+	if p.csvFile != "" {
+		p.csv = newCSVLog(p.log, p.csvFile)
+	}
+
+	if p.controlSocket != "" {
+		p.control = newControlServer(p, p.controlSocket)
+	}
+
+	if p.writeEvents {
+		p.events = newEventStream(p.log, os.Stdout)
+	}
+
+	p.log.Info("Starting the pinger")
+	for _, g := range p.groups {
+		p.log.Info("Watching group", append(g.logFields(),
+			zap.Int("hosts", g.size),
+			zap.Int("active on", g.groupAlive),
+			zap.Int("dead on", g.groupDead))...)
+	}
+	p.logConfig()
+
+	return p
 }
 
-func (p *Ping) Run() error {
-	recv := p.recv()
+// logConfig logs the resolved options and the groups with their hosts, for the bug reports
+func (p *Ping) logConfig() {
+	level := zap.DebugLevel
+	if p.showConfig {
+		level = zap.InfoLevel
+	}
 
-	for {
-		p.seq++
+	p.log.Log(level, "Effective config", p.options...)
+	for _, g := range p.groups {
+		var hosts []string
+		for _, ri := range p.send {
+			if ri.group == g {
+				hosts = append(hosts, ri.String())
+			}
+		}
+		slices.Sort(hosts)
+
+		p.log.Log(level, "Effective group config", append(g.logFields(),
+			zap.Int("group alive", g.groupAlive),
+			zap.Int("group dead", g.groupDead),
+			zap.Strings("alive cmd", g.cmdAlive),
+			zap.Strings("dead cmd", g.cmdDead),
+			zap.Strings("hosts", hosts))...)
+	}
+}
+
+// warnDuplicates logs the hosts listed more than once, as they are counted once
+func (p *Ping) warnDuplicates(targets []target, groups []*group) {
+	if duplicates := duplicateHosts(targets, groups); len(duplicates) > 0 {
+		p.log.Warn("Hosts listed more than once are pinged and counted once", zap.Strings("hosts", duplicates))
+	}
+}
+
+// addTarget starts pinging the target as a member of the group
+func (p *Ping) addTarget(g *group, t target) {
+	conn := p.connFor(t.ip)
+	if conn == nil && !p.probeMode() {
+		p.log.Warn("No socket for the address family, skipping host", zap.Stringer("ip", t.ip))
+		return
+	}
+
+	ri := &remoteInfo{
+		ip:           t.ip,
+		zone:         t.zone,
+		name:         t.name,
+		label:        t.label,
+		addr:         conn.remoteAddr(t.ip, t.zone),
+		conn:         conn,
+		isUp:         false,
+		pingsInState: 0,
+		group:        g,
+		unknown:      p.initialState == stateUnknown,
+		lastChange:   time.Now(),
+		window:       newResultWindow(p.window),
+		broadcast:    p.allowBroadcast && isBroadcast(t.ip, localBroadcasts()),
+		skipped:      true, // a host added mid-round is not pinged until the next one
+	}
+	p.applyTargetOptions(ri, t)
+	p.send[t.address()] = ri
+}
+
+// applyTargetOptions sets the per-host thresholds falling back to the global ones
+func (p *Ping) applyTargetOptions(ri *remoteInfo, t target) {
+	ri.aliveCount = p.aliveCount
+	if t.opts.aliveCount > 0 {
+		ri.aliveCount = t.opts.aliveCount
+	}
+
+	ri.deadCount = p.deadCount
+	if t.opts.deadCount > 0 {
+		ri.deadCount = t.opts.deadCount
+	}
+
+	ri.pause = t.opts.pause
+
+	weight := 1
+	if t.opts.weight > 0 {
+		weight = int(t.opts.weight)
+	}
+	if ri.counted {
+		ri.group.upWeight += weight - ri.weight
+	}
+	if ri.unknown {
+		ri.group.unknownWeight += weight - ri.weight
+	}
+	ri.weight = weight
+}
+
+// removeTarget stops pinging the host, uncounting it from its group
+func (p *Ping) removeTarget(ri *remoteInfo) {
+	if ri.counted {
+		ri.group.uncount(ri)
+		ri.counted = false
+	}
+	if ri.unknown {
+		ri.group.unknownWeight -= ri.weight
+	}
+	delete(p.send, ri.address())
+	p.metrics.forget(ri)
+}
+
+// hasFamily returns whether any of the configured hosts is of IPv6, or of IPv4 if not ipv6
+func (p *Ping) hasFamily(ipv6 bool) bool {
+	for _, t := range p.targets {
+		if (t.ip.To4() == nil) == ipv6 {
+			return true
+		}
+	}
+	for _, g := range p.groups {
+		for _, t := range g.targets {
+			if (t.ip.To4() == nil) == ipv6 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// connFor returns the socket used to ping the ip, nil if its family is not served
+func (p *Ping) connFor(ip net.IP) *icmpConn {
+	if ip.To4() == nil {
+		return p.conn6
+	}
+	return p.conn4
+}
+
+// Run pings the hosts until the count of rounds is reached or the context
+// is done, returning the error of the context then
+func (p *Ping) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	// the embedding programs have no flags to reload
+	hangup := make(chan os.Signal, 1)
+	if p.commandLine {
+		signal.Notify(hangup, syscall.SIGHUP)
+		defer signal.Stop(hangup)
+	}
+
+	recv := make(chan icmpInfo)
+	p.replies = recv
+	p.stopped = make(chan struct{})
+	p.failed = make(chan error, 2)
+	for _, c := range p.conns() {
+		p.recv(c, recv)
+	}
+	defer p.close()
+	defer p.commands.Wait()
+	defer p.saveState()
+
+	if p.resolveEvery > 0 {
+		go p.resolveLoop(done)
+	}
+
+	p.mu.Lock()
+	p.lookupPTRs()
+	p.mu.Unlock()
+
+	if p.logLevel != (zap.AtomicLevel{}) {
+		toggle := make(chan os.Signal, 1)
+		notifyToggle(toggle)
+		defer signal.Stop(toggle)
+		go p.toggleLevelLoop(toggle, done)
+	}
+
+	p.metrics.start()
+	defer p.metrics.close()
+
+	p.status.start()
+	defer p.status.close()
+
+	defer p.influx.close()
+	defer p.statsd.close()
+	defer p.csv.close()
+
+	p.control.start()
+	defer p.control.close()
+
+	if p.count > 0 {
+		defer p.printSummary(os.Stdout)
+	}
+
+	for round := 1; ; round++ {
+		// the paused rounds neither send nor count towards the round limit
+		if p.isPaused() {
+			round--
+			p.heartbeat()
+			if !p.pause(ctx, hangup) {
+				return p.stopReason(ctx)
+			}
+			continue
+		}
+
+		p.seq += p.roundSeqs()
 
 		if err := p.sendRequests(); err != nil {
 			return err
 		}
 
-		p.gatherResponses(recv)
+		if !p.gatherResponses(ctx, recv) {
+			return p.stopReason(ctx)
+		}
+
+		if p.roundSummary {
+			p.logRoundSummary(round)
+		}
+
+		if round == p.count {
+			if !p.everAlive() {
+				return ErrNeverAlive
+			}
+			return nil
+		}
+
+		// checked once per round, so the deadline is late by up to one
+		if p.startupTimeout > 0 && time.Since(p.epoch) >= p.startupTimeout && !p.everAlive() {
+			p.log.Error("The group has not become alive within the startup timeout", zap.Duration("timeout", p.startupTimeout))
+			return ErrStartupTimeout
+		}
+
+		p.heartbeat()
+		if !p.pause(ctx, hangup) {
+			return p.stopReason(ctx)
+		}
+	}
+}
+
+// everAlive returns whether all the groups have ever been alive
+func (p *Ping) everAlive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, g := range p.groups {
+		if !g.wasAlive {
+			return false
+		}
+	}
+	return true
+}
 
-		time.Sleep(p.pauseDuration)
+// stopReason returns the error which stopped Run, the broken socket or the context one
+func (p *Ping) stopReason(ctx context.Context) error {
+	if p.fatal != nil {
+		return p.fatal
 	}
+	p.log.Info("Stopping the pinger", zap.NamedError("reason", context.Cause(ctx)))
+	return ctx.Err()
 }
 
+// pause waits between the rounds reloading the config on hangup,
+// returns false if the pinger was stopped by the context or a broken socket
+func (p *Ping) pause(ctx context.Context, hangup chan os.Signal) bool {
+	p.mu.Lock()
+	p.updateTick()
+	pause := p.jitter.apply(p.tick)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-hangup:
+			p.reload()
+		case <-ctx.Done():
+			return false
+		case p.fatal = <-p.failed:
+			return false
+		}
+	}
+}
+
+// IsAlive returns whether all the groups are currently alive
+func (p *Ping) IsAlive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, g := range p.groups {
+		if !g.isTotalAlive {
+			return false
+		}
+	}
+	return true
+}
+
+// UseExitCode returns whether the process exit code should reflect the group state
+func (p *Ping) UseExitCode() bool {
+	return p.exitCode
+}
+
+// AddTarget starts pinging the hosts of the target, given as on the command line,
+// as members of the named group, the command line one for the empty name.
+// The hosts start down and are counted by the group right away.
+func (p *Ping) AddTarget(arg, groupName string) error {
+	targets, err := parseTarget(arg)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var g *group
+	for _, candidate := range p.groups {
+		if candidate.name == groupName {
+			g = candidate
+		}
+	}
+	if g == nil {
+		return fmt.Errorf("no group %s", groupName)
+	}
+
+	all := targets
+	for _, ri := range p.send {
+		all = append(all, target{ip: ri.ip})
+	}
+	if err = p.checkBroadcasts(all); err != nil {
+		return err
+	}
+	if err = p.checkHostOptions(targets); err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if ri, ok := p.send[t.address()]; ok {
+			return fmt.Errorf("host %s is already pinged in %s", t.address(), ri.group.describe())
+		}
+		if p.connFor(t.ip) == nil && !p.probeMode() {
+			return fmt.Errorf("no socket for the address family of %s", t.ip)
+		}
+	}
+
+	for _, t := range targets {
+		p.addTarget(g, t)
+		g.targets = append(g.targets, t)
+		ri := p.send[t.address()]
+		p.metrics.setUp(ri)
+		p.log.Info("Added host", append(g.logFields(), zap.Stringer("ip", ri))...)
+	}
+
+	p.updateGroups()
+	p.settleGroups()
+	p.lookupPTRs()
+	return nil
+}
+
+// RemoveTarget stops pinging the host, uncounting it from its group if it was up
+func (p *Ping) RemoveTarget(arg string) error {
+	addr, zone, zoned := strings.Cut(unbracket(arg), "%")
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", arg)
+	}
+	if zoned {
+		targets, err := parseZoned(addr, zone)
+		if err != nil {
+			return err
+		}
+		zone = targets[0].zone
+	}
+	key := zonedAddress(ip, zone)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ri, ok := p.send[key]
+	if !ok {
+		return fmt.Errorf("host %s is not pinged", key)
+	}
+
+	g := ri.group
+	p.removeTarget(ri)
+	for i, t := range g.targets {
+		if t.address() == key {
+			g.targets = append(g.targets[:i], g.targets[i+1:]...)
+			break
+		}
+	}
+	p.log.Info("Removed host", append(g.logFields(), zap.Stringer("ip", ri))...)
+
+	p.updateGroups()
+	p.settleGroups()
+	return nil
+}
+
+// close closes the sockets, which unblocks the receiving goroutines,
+// and waits for them to return
+func (p *Ping) close() {
+	close(p.stopped)
+	for _, c := range p.conns() {
+		_ = c.conn.Close()
+	}
+	p.receivers.Wait()
+}
+
+// deliver passes the reply to the round, dropping it once the pinger is stopped
+func (p *Ping) deliver(ch chan icmpInfo, i icmpInfo) {
+	select {
+	case ch <- i:
+	case <-p.stopped:
+	}
+}
+
+// sendRequests sends the echo requests of the round to all the due hosts,
+// spreading them over the configured number of concurrent senders. The requests
+// are paced and sent to the copies of the hosts without holding the lock, so the
+// status and the control socket are served meanwhile.
 func (p *Ping) sendRequests() error {
+	p.mu.Lock()
+	p.roundAt = p.monotonic()
+	p.updateTick()
+	p.assignSeqs()
+	var due []*remoteInfo
+	for _, ri := range p.send {
+		if p.prepareRequest(ri) {
+			due = append(due, ri)
+		}
+	}
+	copies := make([]remoteInfo, len(due))
+	for i, ri := range due {
+		copies[i] = *ri
+	}
+	p.mu.Unlock()
+
+	sent := make([]int, len(due))
+	hosts := make(chan int)
+	errs := make([]error, p.sendConcurrency)
+
+	var wg sync.WaitGroup
+	for w := range p.sendConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range hosts {
+				var err error
+				if sent[i], err = p.sendRequest(&copies[i]); err != nil && errs[w] == nil {
+					errs[w] = err
+				}
+			}
+		}()
+	}
+
+	for i := range due {
+		hosts <- i
+	}
+	close(hosts)
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, ri := range due {
+		ri.sent += sent[i]
+		ri.sendFailed = sent[i] == 0
+		// the host removed meanwhile keeps no metrics
+		if p.send[ri.address()] != ri {
+			continue
+		}
+		for range sent[i] {
+			p.metrics.packetSent(ri)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// assignSeqs gives every host its own sequence numbers in the match-id-only mode,
+// so a reply coming from an address other than the host's is still told apart
+func (p *Ping) assignSeqs() {
+	if !p.matchIDOnly {
+		return
+	}
+
+	p.seqHosts = p.seqHosts[:0]
+	for _, ri := range p.send {
+		ri.seqOffset = uint16(len(p.seqHosts) * p.probesPerRound)
+		p.seqHosts = append(p.seqHosts, ri)
+	}
+}
+
+// roundSeqs returns the number of sequence numbers taken by the last round
+func (p *Ping) roundSeqs() uint16 {
+	if p.matchIDOnly {
+		return uint16(len(p.seqHosts) * p.probesPerRound)
+	}
+	return uint16(p.probesPerRound)
+}
+
+// seqHost returns the host the sequence number of the round was sent to in the match-id-only mode
+func (p *Ping) seqHost(seq uint16) (*remoteInfo, bool) {
+	n := int(seq-p.seq) / p.probesPerRound
+	if !p.matchIDOnly || n >= len(p.seqHosts) {
+		return nil, false
+	}
+	return p.seqHosts[n], true
+}
+
+// prepareRequest starts the round of the host, returns whether it is due for a ping
+func (p *Ping) prepareRequest(ri *remoteInfo) bool {
+	ri.gotReply = false
+	ri.replied = 0
+	ri.sendFailed = false
+
+	now := p.monotonic()
+	if ri.skipped = ri.sent > 0 && now < ri.lastPing+p.hostPause(ri); ri.skipped {
+		return false
+	}
+	ri.lastPing = now
+	if ri.conn != nil && p.ecmpProbe > 1 {
+		ri.conn = p.ecmpConn(ri)
+	}
+	return true
+}
+
+// sendRequest sends the probes of the round to the copy of the host, returns the
+// number of them sent. The message is built per host so the concurrent senders
+// share nothing but the socket.
+func (p *Ping) sendRequest(ri *remoteInfo) (int, error) {
+	// the probes of the round carry the consecutive sequence numbers
+	sent := 0
+	for k := range p.probesPerRound {
+		if p.limiter != nil {
+			if err := p.limiter.Wait(context.Background()); err != nil {
+				return sent, err
+			}
+		}
+
+		seq := p.seq + ri.seqOffset + uint16(k)
+		if p.tcpPort > 0 {
+			p.probeTCP(ri, seq)
+		} else if p.httpURL != "" {
+			p.probeHTTP(ri, seq)
+		} else if ok, err := p.sendEcho(ri, seq); err != nil {
+			return sent, err
+		} else if !ok {
+			continue
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// sendEcho writes the echo request to the socket, a failure to do so
+// is logged and reported as not sent instead of being returned
+func (p *Ping) sendEcho(ri *remoteInfo, seq uint16) (bool, error) {
+	data := make([]byte, p.payloadSize)
+	encodeTimestamp(data, p.monotonic())
+	p.payloadPattern.apply(data[timestampSize:])
 	wm := icmp.Message{
-		Type: ipv4.ICMPTypeEcho, Code: 0,
+		Type: ri.conn.echoType, Code: 0,
 		Body: &icmp.Echo{
-			ID:   int(p.pid),
-			Seq:  int(p.seq),
-			Data: []byte(""),
+			ID:   int(ri.conn.pid),
+			Seq:  int(seq),
+			Data: data,
 		},
 	}
 	wb, err := wm.Marshal(nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	_, err = ri.conn.conn.WriteTo(wb, ri.addr)
+	if err != nil && p.drainErrQueue(ri.conn) {
+		// the send reported the ICMP error queued by an earlier request, not its own
+		_, err = ri.conn.conn.WriteTo(wb, ri.addr)
+	}
+	// the send buffer filled under load is likely drained within milliseconds
+	for retry := 0; err != nil && retry < sendRetries && transientSendError(err); retry++ {
+		p.log.Debug("Retrying the ICMP message", zap.Stringer("ip", ri), zap.Error(err))
+		time.Sleep(sendBackoff << retry)
+		_, err = ri.conn.conn.WriteTo(wb, ri.addr)
+	}
+	if err != nil {
+		p.log.Error("Failed to send ICMP message", zap.Stringer("ip", ri), zap.Error(err))
+		return false, nil
+	}
+	return true, nil
+}
+
+// hostPause returns the delay before the next ping of the host, 0 to ping it every
+// round. The rounds go every shortest pause of the hosts, or every minPause in the
+// adaptive mode, where the hosts between their states are pinged in each of them,
+// while the others wait for their own pause.
+func (p *Ping) hostPause(ri *remoteInfo) time.Duration {
+	if p.minPause > 0 && ri.isUp != ri.stableIsUp {
+		return 0
 	}
 
+	pause := p.pauseDuration
+	if ri.pause > 0 {
+		pause = ri.pause
+	}
+	if pause <= p.tick {
+		return 0
+	}
+	return pause
+}
+
+// updateTick sets the pause between the rounds to the shortest one of the hosts
+func (p *Ping) updateTick() {
+	pause := p.pauseDuration
+	if p.minPause > 0 {
+		pause = p.minPause
+	}
 	for _, ri := range p.send {
-		ri.gotReply = false
-		if _, err = p.conn.WriteTo(wb, ri.addr); err != nil {
-			p.log.Error("Failed to send ICMP message", zap.Error(err))
+		if ri.pause > 0 && ri.pause < pause {
+			pause = ri.pause
 		}
 	}
+	p.tick = pause
+}
 
-	return nil
+// monotonic returns the monotonic time since the pinger start
+func (p *Ping) monotonic() time.Duration {
+	return time.Since(p.epoch)
 }
 
-func (p *Ping) gatherResponses(recv chan icmpInfo) {
+// gatherResponses processes the replies until the wait timeout,
+// returns false if the pinger was stopped by the context or a broken socket
+func (p *Ping) gatherResponses(ctx context.Context, recv chan icmpInfo) bool {
 
 	timer := time.NewTimer(p.waitTimeout)
 
@@ -130,183 +1115,444 @@ func (p *Ping) gatherResponses(recv chan icmpInfo) {
 		select {
 		case <-timer.C:
 			timer.Stop()
-			for ip, v := range p.send {
-				if !v.gotReply {
-					if v.isUp {
-						v.isUp = false
-						v.pingsInState = 1
-					} else {
-						v.pingsInState += 1
-					}
-					p.log.Debug("Ping timed out", zap.String("ip", ip), zap.Int("count", v.pingsInState))
-
-					if v.pingsInState == int(p.deadCount) && v.stableIsUp {
-						p.log.Info("Remote host is dead", zap.String("ip", ip))
-						v.stableIsUp = false
-						p.handleHostDead()
-					}
-				}
-			}
-			return
+			p.mu.Lock()
+			p.handleTimeouts()
+			p.checkStableGroups()
+			p.assessGroups()
+			p.replaySuppressed()
+			p.influx.writeRound(p.send, p.groups)
+			p.statsd.writeRound(p.send, p.groups)
+			p.csv.writeRound(p.send, time.Now())
+			p.mu.Unlock()
+			return true
+
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+
+		case p.fatal = <-p.failed:
+			timer.Stop()
+			return false
 
 		case i := <-recv:
-			s := i.ip.String()
-			v, ok := p.send[s]
-			if !ok || uint16(i.echo.ID) != p.pid || uint16(i.echo.Seq) != p.seq {
-				continue
-			}
+			p.mu.Lock()
+			p.handleReply(i)
+			p.mu.Unlock()
+		}
+	}
+}
 
-			v.gotReply = true
-			if !v.isUp {
-				v.isUp = true
-				v.pingsInState = 1
-			} else {
-				v.pingsInState += 1
-			}
+func (p *Ping) handleTimeouts() {
+	for _, v := range p.send {
+		if v.gotReply || v.skipped {
+			continue
+		}
 
-			p.log.Debug("Successful ping", zap.String("ip", s), zap.Int("count", v.pingsInState))
+		// a local send failure says nothing about the remote host
+		if v.sendFailed {
+			p.log.Debug("Ping not sent, ignoring the round", zap.Stringer("ip", v))
+			continue
+		}
 
-			if v.pingsInState == int(p.aliveCount) && !v.stableIsUp {
-				p.log.Info("Remote host is alive", zap.String("ip", s))
-				v.stableIsUp = true
-				p.handleHostAlive()
-			}
+		// past the count the state is settled, so the number stops growing
+		if v.window != nil {
+			// the timeouts within the window count, however interleaved with the replies
+			v.window.add(false)
+			v.isUp, v.pingsInState = false, v.window.count(false)
+		} else if v.isUp {
+			v.isUp = false
+			v.pingsInState = 1
+		} else if v.pingsInState < int(v.deadCount) {
+			v.pingsInState += 1
+		}
+		p.log.Debug("Ping timed out", zap.Stringer("ip", v), zap.Int("count", v.pingsInState))
+
+		if v.pingsInState >= int(v.deadCount) && (v.stableIsUp || v.unknown) {
+			p.log.Info("Remote host is dead", zap.Stringer("ip", v), zap.Duration("after", v.changeState()))
+			v.stableIsUp = false
+			v.slow, v.slowInState, v.degraded = false, 0, false
+			p.metrics.setUp(v)
+			p.events.host(v, stateDead)
+			p.handleHostDead(v)
+			p.runHostCommand(p.cmdHostDead, v, stateDead)
+			p.resolveEarly(v)
 		}
 	}
 }
 
-func (p *Ping) handleHostAlive() {
-	p.totalAlive += 1
-	if !p.isTotalAlive && p.totalAlive >= int(p.groupAlive) {
-		p.log.Info("Transitioning to alive state")
-		p.runCommand(p.cmdAlive)
-		p.isTotalAlive = true
+func (p *Ping) handleReply(i icmpInfo) {
+	v, ok := p.send[zonedAddress(i.ip, i.zone)]
+	if !ok && p.allowBroadcast {
+		v, ok = p.broadcastFor(i.ip)
+	}
+	if ri, found := p.seqHost(uint16(i.echo.Seq)); found && ri != v {
+		// behind a NAT or with asymmetric routing another address replies for the host
+		p.log.Debug("Reply from an unexpected source", zap.Stringer("ip", ri), zap.Stringer("from", i.ip))
+		v, ok = ri, true
+	}
+	// any of the probes of the round may be the one replied to
+	if !ok || v.conn != nil && uint16(i.echo.ID) != v.conn.pid || uint16(i.echo.Seq)-p.seq-v.seqOffset >= uint16(p.probesPerRound) {
+		return
+	}
+
+	// the seq wraps around, a late reply of an old round may carry the current one
+	sent, hasRTT := decodeTimestamp(i.echo.Data)
+	if hasRTT && sent < p.roundAt {
+		p.log.Debug("Ignoring a stale reply", zap.Stringer("ip", v), zap.Duration("age", i.received-sent))
+		return
+	}
+
+	if v.broadcast {
+		// every responder replies, the first one answers the round
+		if v.gotReply {
+			return
+		}
+		p.log.Debug("Reply to the broadcast", zap.Stringer("ip", v), zap.Stringer("from", i.ip))
+	}
+
+	// a duplicate points at a routing loop or a misbehaving middlebox, it is not counted
+	probe := uint16(i.echo.Seq) - p.seq - v.seqOffset
+	if v.replied&(1<<probe) != 0 {
+		p.log.Warn("Duplicate reply", zap.Stringer("ip", v), zap.Int("seq", i.echo.Seq))
+		return
+	}
+	if v.replied>>probe != 0 {
+		p.log.Info("Reply out of order", zap.Stringer("ip", v), zap.Int("seq", i.echo.Seq))
+	}
+	v.replied |= 1 << probe
+
+	if v.conn != nil {
+		p.checkPayload(v, i.echo.Data)
+	}
+
+	// the warm-up before the alive count shows the host is reachable at all
+	if v.received == 0 {
+		fields := []zap.Field{zap.Stringer("ip", v)}
+		if hasRTT {
+			fields = append(fields, zap.Duration("rtt", i.received-sent))
+		}
+		p.log.Info("First reply", fields...)
+	}
+
+	v.received++
+	p.metrics.packetReceived(v, i.received-sent, hasRTT)
+	if hasRTT {
+		v.rtt.add(i.received - sent)
+	}
+
+	// the replies to the other probes of the round do not count again
+	if v.gotReply {
+		return
+	}
+	v.gotReply = true
+	if v.window != nil {
+		v.window.add(true)
+		v.isUp, v.pingsInState = true, v.window.count(true)
+	} else if !v.isUp {
+		v.isUp = true
+		v.pingsInState = 1
+	} else if v.pingsInState < int(v.aliveCount) {
+		v.pingsInState += 1
+	}
+
+	fields := []zap.Field{zap.Stringer("ip", v), zap.Int("count", v.pingsInState)}
+	if hasRTT {
+		fields = append(fields,
+			zap.Duration("rtt", v.rtt.last),
+			zap.Duration("min", v.rtt.min),
+			zap.Duration("avg", v.rtt.avg()),
+			zap.Duration("max", v.rtt.max))
+	}
+
+	p.log.Debug("Successful ping", fields...)
+
+	if v.pingsInState >= int(v.aliveCount) && !v.stableIsUp {
+		p.log.Info("Remote host is alive", zap.Stringer("ip", v), zap.Duration("after", v.changeState()))
+		v.stableIsUp = true
+		p.metrics.setUp(v)
+		p.events.host(v, stateAlive)
+		p.handleHostAlive(v)
+		p.runHostCommand(p.cmdHostAlive, v, stateAlive)
+	}
+
+	if hasRTT {
+		p.updateDegraded(v, v.rtt.last)
 	}
 }
 
-func (p *Ping) handleHostDead() {
-	p.totalAlive -= 1
-	if p.isTotalAlive && p.totalAlive <= int(p.groupDead) {
-		p.log.Info("Transitioning to dead state")
-		p.runCommand(p.cmdDead)
-		p.isTotalAlive = false
+// broadcastFor returns the broadcast or multicast host of the address family of the
+// responder, there is at most one
+func (p *Ping) broadcastFor(ip net.IP) (*remoteInfo, bool) {
+	for _, ri := range p.send {
+		if ri.broadcast && (ri.ip.To4() != nil) == (ip.To4() != nil) {
+			return ri, true
+		}
 	}
+	return nil, false
 }
 
-func (p *Ping) runCommand(command string) {
-	p.log.Debug("Running command", zap.String("command", command))
-	cmd := exec.Command("/bin/sh", "-c", command)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
+// checkPayload reports the replies carrying back other data than sent, a sign of
+// corruption on the path, which still count as replies like with ping
+func (p *Ping) checkPayload(v *remoteInfo, data []byte) {
+	if len(data) != p.payloadSize {
+		p.log.Warn("Corrupted reply", zap.Stringer("ip", v), zap.Int("size", len(data)), zap.Int("sent", p.payloadSize))
 		return
 	}
-	_ = cmd.Wait()
+
+	if offset := p.payloadPattern.mismatch(data[timestampSize:]); offset >= 0 {
+		p.log.Warn("Corrupted reply", zap.Stringer("ip", v),
+			zap.Int("offset", timestampSize+offset),
+			zap.String("got", fmt.Sprintf("0x%02x", data[timestampSize+offset])),
+			zap.String("want", fmt.Sprintf("0x%02x", p.payloadPattern.at(offset))))
+	}
+}
+
+// updateDegraded compares the rtt of the host to the threshold, an alive host
+// replying slower than it for degradedCount rounds is degraded until it
+// replies faster for as many rounds
+func (p *Ping) updateDegraded(v *remoteInfo, rtt time.Duration) {
+	if p.rttThreshold == 0 {
+		return
+	}
+
+	slow := rtt > p.rttThreshold
+	if slow != v.slow {
+		v.slow = slow
+		v.slowInState = 1
+	} else {
+		v.slowInState += 1
+	}
+
+	if !v.stableIsUp || v.degraded == slow || v.slowInState < int(p.degradedCount) {
+		return
+	}
+	v.degraded = slow
+	p.metrics.setUp(v)
+
+	if slow {
+		p.log.Warn("Remote host is degraded", zap.Stringer("ip", v), zap.Duration("rtt", rtt))
+		p.events.host(v, stateDegraded)
+		p.runHostCommand(p.cmdDegraded, v, stateDegraded)
+		return
+	}
+	p.log.Info("Remote host is no longer degraded", zap.Stringer("ip", v), zap.Duration("rtt", rtt))
+	p.events.host(v, stateAlive)
 }
 
 type icmpInfo struct {
-	ip   net.IP
-	echo icmp.Echo
+	ip       net.IP
+	zone     string // interface the link-local reply came from
+	echo     icmp.Echo
+	received time.Duration // monotonic receive time
 }
 
-func (p *Ping) recv() chan icmpInfo {
-	ch := make(chan icmpInfo)
+// recv reads the socket in the background until it is closed
+func (p *Ping) recv(c *icmpConn, ch chan icmpInfo) {
+	p.receivers.Add(1)
+	if c.batch != nil {
+		go p.recvBatch(c, ch)
+		return
+	}
 
 	go func() {
-		rb := make([]byte, 1500)
-		for {
-			n, peer, err := p.conn.ReadFrom(rb)
-			if err != nil {
-				p.log.Error("Failed to receive ICMP message", zap.Error(err))
-				continue
-			}
+		defer p.receivers.Done()
 
-			if n == 0 {
-				close(ch)
-				break
+		rb := make([]byte, p.recvBufferSize())
+		failures := 0
+		for {
+			n, peer, err := c.conn.ReadFrom(rb)
+			if errors.Is(err, net.ErrClosed) {
+				return
 			}
-
-			addr, ok := peer.(*net.UDPAddr)
-			if !ok {
-				p.log.Error("Failed to extract UDP address", zap.String("peer", peer.String()))
+			// the ICMP errors of the unprivileged sockets surface as the errno of a receive
+			if err != nil && !p.drainErrQueue(c) {
+				failures++
+				p.log.Error("Failed to receive ICMP message", zap.Error(err), zap.Int("failures", failures))
+				if !p.recvFailed(err, failures) {
+					return
+				}
 				continue
 			}
-
-			rm, err := icmp.ParseMessage(1, rb[:n])
+			failures = 0
 			if err != nil {
-				p.log.Error("Failed to parse ICMP message", zap.Error(err))
 				continue
 			}
 
-			if rm.Type != ipv4.ICMPTypeEchoReply {
-				continue
+			p.checkTruncated(c, n, len(rb))
+			p.handleMessage(c, ch, rb[:n], peer)
+		}
+	}()
+}
+
+// recvBatch is recv reading several messages per system call
+func (p *Ping) recvBatch(c *icmpConn, ch chan icmpInfo) {
+	defer p.receivers.Done()
+
+	ms := make([]ipv4.Message, recvBatchSize)
+	for i := range ms {
+		ms[i].Buffers = [][]byte{make([]byte, p.recvBufferSize())}
+	}
+
+	failures := 0
+	for {
+		n, err := c.batch.ReadBatch(ms, 0)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil && !p.drainErrQueue(c) {
+			failures++
+			p.log.Error("Failed to receive ICMP messages", zap.Error(err), zap.Int("failures", failures))
+			if !p.recvFailed(err, failures) {
+				return
 			}
+			continue
+		}
+		failures = 0
+		if err != nil {
+			continue
+		}
 
-			echo, ok := rm.Body.(*icmp.Echo)
-			if !ok {
-				p.log.Error("Failed to extract body from ICMP message", zap.String("peer", peer.String()))
+		for _, m := range ms[:n] {
+			if m.N == 0 {
 				continue
 			}
 
-			ch <- icmpInfo{
-				ip:   addr.IP,
-				echo: *echo,
+			p.checkTruncated(c, m.N, len(m.Buffers[0]))
+			data := m.Buffers[0][:m.N]
+			if c.raw && c.proto == protocolICMP {
+				data = stripIPv4Header(data)
 			}
+			p.handleMessage(c, ch, data, m.Addr)
 		}
-	}()
+	}
+}
 
-	return ch
+// recvFailed waits before retrying the read after the consecutive failures of a socket,
+// doubling the delay each time. Returns false if the receiver should stop, either on
+// shutdown or after recvMaxFailures, which are reported to Run as a fatal error.
+func (p *Ping) recvFailed(err error, failures int) bool {
+	if failures >= recvMaxFailures {
+		select {
+		case p.failed <- fmt.Errorf("giving up receiving after %d failures: %w", failures, err):
+		default:
+		}
+		return false
+	}
+
+	delay := min(recvBackoffMin<<(failures-1), recvBackoffMax)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-p.stopped:
+		return false
+	}
 }
 
-func (p *Ping) readArguments() bool {
-	generalOptions := pflag.NewFlagSet("General", pflag.ExitOnError)
-	generalOptions.SortFlags = false
-	verbose := generalOptions.BoolP("verbose", "v", false, "Enable verbose logging")
-	generalOptions.StringVarP(&p.cmdAlive, "alive-cmd", "a", "", "Command to run when network is alive")
-	generalOptions.StringVarP(&p.cmdDead, "dead-cmd", "d", "", "Command to run when network is dead")
-	pflag.CommandLine.AddFlagSet(generalOptions)
+// drainErrQueue logs the ICMP errors queued to the socket,
+// returns false if there were none
+func (p *Ping) drainErrQueue(c *icmpConn) bool {
+	if c.socket == nil || c.raw {
+		return false
+	}
+
+	errs, err := readErrQueue(c.socket)
+	if err != nil && !errors.Is(err, errRecvErr) {
+		p.log.Error("Failed to read the ICMP errors", zap.Error(err))
+	}
+
+	for _, e := range errs {
+		if !c.ownEcho(e.data) {
+			continue
+		}
+		switch {
+		case c.icmpType(e.typ) == c.ttlType:
+			p.logTimeExceeded(e.dst, e.offender)
+		case c.tooBig(e):
+			p.logFragmentationNeeded(e.dst, int(e.info), e.offender)
+		case c.icmpType(e.typ) == c.unreach:
+			p.logUnreachable(e.dst, c.unreachableReason(int(e.code)), e.offender)
+		}
+	}
+	return len(errs) > 0
+}
 
-	pingOptions := pflag.NewFlagSet("Ping", pflag.ExitOnError)
-	pingOptions.SortFlags = false
-	pingOptions.DurationVar(&p.waitTimeout, "wait", time.Second, "Single ping wait timeout")
-	pingOptions.DurationVar(&p.pauseDuration, "pause", 5*time.Second, "Between ping pause duration")
-	pingOptions.Uint8Var(&p.aliveCount, "alive-count", 3, "Number of alive pings to consider host alive")
-	pingOptions.Uint8Var(&p.deadCount, "dead-count", 3, "Number of alive pings to consider host dead")
-	pflag.CommandLine.AddFlagSet(pingOptions)
+// logTimeExceeded reports the echo request to the ip dropped by the router
+func (p *Ping) logTimeExceeded(ip, from net.IP) {
+	p.log.Warn("Time to live exceeded", zap.Stringer("ip", ip), zap.Stringer("from", from))
+}
 
-	groupOptions := pflag.NewFlagSet("Group", pflag.ExitOnError)
-	groupOptions.SortFlags = false
-	groupOptions.Uint8Var(&p.groupAlive, "group-alive", 0, "number of alive hosts to consider whole setup alive (default ip count)")
-	groupOptions.Uint8Var(&p.groupDead, "group-dead", 0, "number of alive hosts to consider whole setup dead (default 0)")
-	pflag.CommandLine.AddFlagSet(groupOptions)
+// logFragmentationNeeded reports the echo request to the ip dropped by the router
+// as too big for the next hop with the don't fragment bit set
+func (p *Ping) logFragmentationNeeded(ip net.IP, mtu int, from net.IP) {
+	p.log.Warn("Fragmentation needed", zap.Stringer("ip", ip), zap.Int("mtu", mtu), zap.Stringer("from", from))
+}
 
-	pflag.Usage = func() {
-		_, _ = fmt.Fprintf(os.Stderr, "USAGE: %s [options] <ip> [<ip> ...]\n", os.Args[0])
+// logUnreachable reports the echo request to the ip rejected by the router,
+// telling an unreachable host apart from the one which does not reply
+func (p *Ping) logUnreachable(ip net.IP, reason string, from net.IP) {
+	p.log.Info("Destination unreachable", zap.Stringer("ip", ip), zap.String("reason", reason), zap.Stringer("from", from))
+}
 
-		_, _ = fmt.Fprint(os.Stderr, "\nGeneral options:\n")
-		generalOptions.PrintDefaults()
+// handleMessage parses a received message and passes the echo replies to the channel
+func (p *Ping) handleMessage(c *icmpConn, ch chan icmpInfo, data []byte, peer net.Addr) {
+	ip, zone := peerAddr(peer)
+	if ip == nil {
+		p.log.Error("Failed to extract peer address", zap.Stringer("peer", peer))
+		return
+	}
 
-		_, _ = fmt.Fprint(os.Stderr, "\nPing options:\n")
-		pingOptions.PrintDefaults()
+	rm, err := icmp.ParseMessage(c.proto, data)
+	if err != nil {
+		p.log.Error("Failed to parse ICMP message", zap.Error(err))
+		return
+	}
 
-		_, _ = fmt.Fprint(os.Stderr, "\nGrouping options:\n")
-		groupOptions.PrintDefaults()
+	if mtu, orig, ok := c.fragmentationNeeded(rm, data); ok {
+		if dst, own := c.quotedEcho(orig); own {
+			p.logFragmentationNeeded(dst, mtu, ip)
+		}
+		return
 	}
 
-	pflag.Parse()
+	if rm.Type == c.ttlType {
+		if body, ok := rm.Body.(*icmp.TimeExceeded); ok {
+			if dst, own := c.quotedEcho(body.Data); own {
+				p.logTimeExceeded(dst, ip)
+			}
+		}
+		return
+	}
 
-	for _, arg := range pflag.Args() {
-		ip := net.ParseIP(arg)
-		if ip == nil {
-			pflag.Usage()
+	if rm.Type == c.unreach {
+		if body, ok := rm.Body.(*icmp.DstUnreach); ok {
+			if dst, own := c.quotedEcho(body.Data); own {
+				p.logUnreachable(dst, c.unreachableReason(rm.Code), ip)
+			}
 		}
-		p.ips = append(p.ips, ip)
+		return
 	}
 
-	if len(p.ips) == 0 {
-		pflag.Usage()
+	if rm.Type != c.replyType {
+		return
+	}
+
+	echo, ok := rm.Body.(*icmp.Echo)
+	if !ok {
+		p.log.Error("Failed to extract body from ICMP message", zap.String("peer", peer.String()))
+		return
+	}
+	// the raw sockets of the ECMP mode each receive the replies to all of them
+	if uint16(echo.ID) != c.pid {
+		return
 	}
 
-	return *verbose
+	p.deliver(ch, icmpInfo{
+		ip:       ip,
+		zone:     zone,
+		echo:     *echo,
+		received: p.monotonic(),
+	})
 }
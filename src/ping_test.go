@@ -0,0 +1,1015 @@
+package src
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeConn records the echo requests instead of sending them
+type fakeConn struct {
+	mu      sync.Mutex
+	written map[string][]byte // last request sent to each ip
+	fail    map[string]bool   // ips the requests to which fail to be sent
+	nobufs  map[string]int    // number of the next requests to the ip failing for the lack of buffer space
+	closed  chan struct{}
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{written: make(map[string][]byte), fail: make(map[string]bool), nobufs: make(map[string]int), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) ReadFrom([]byte) (int, net.Addr, error) {
+	<-c.closed
+	return 0, nil, net.ErrClosed
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ip := zonedAddress(peerAddr(dst))
+	if c.fail[ip] {
+		return 0, errors.New("fake send failure")
+	}
+	if c.nobufs[ip] > 0 {
+		c.nobufs[ip]--
+		return 0, syscall.ENOBUFS
+	}
+	c.written[ip] = append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (c *fakeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *fakeConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 4242}
+}
+
+// newTestPing returns a pinger of the hosts on top of a fake connection
+func newTestPing(t *testing.T, configure func(p *Ping), hosts ...string) (*Ping, *fakeConn) {
+	t.Helper()
+
+	p := &Ping{
+		waitTimeout:     time.Second,
+		pauseDuration:   5 * time.Second,
+		aliveCount:      2,
+		deadCount:       2,
+		probesPerRound:  1,
+		sendConcurrency: 1,
+		payloadSize:     56,
+		cmdTimeout:      time.Second,
+	}
+	for _, host := range hosts {
+		targets, err := parseTarget(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.targets = append(p.targets, targets...)
+	}
+	if configure != nil {
+		configure(p)
+	}
+
+	conn := newFakeConn()
+	newPing(p, zap.NewNop(), conn, nil)
+	t.Cleanup(p.commands.Wait)
+	return p, conn
+}
+
+// reply returns the reply to the last echo request sent to the ip
+func (c *fakeConn) reply(t *testing.T, ip string, received time.Duration) icmpInfo {
+	t.Helper()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addr, zone, _ := strings.Cut(ip, "%")
+	proto := protocolICMP
+	if net.ParseIP(addr).To4() == nil {
+		proto = protocolIPv6ICMP
+	}
+	rm, err := icmp.ParseMessage(proto, c.written[ip])
+	if err != nil {
+		t.Fatalf("no echo request sent to %s: %v", ip, err)
+	}
+	return icmpInfo{ip: net.ParseIP(addr), zone: zone, echo: *rm.Body.(*icmp.Echo), received: received}
+}
+
+// runRounds plays the rounds of the hosts, each character of a host pattern is
+// a round: + for a reply, s for a reply a second late, - for a timeout and x for
+// a failure to send the request
+func runRounds(t *testing.T, p *Ping, conn *fakeConn, patterns map[string]string) {
+	t.Helper()
+
+	rounds := 0
+	for _, pattern := range patterns {
+		rounds = max(rounds, len(pattern))
+	}
+
+	for round := range rounds {
+		for ip, pattern := range patterns {
+			conn.fail[ip] = pattern[round] == 'x'
+		}
+		if err := p.sendRequests(); err != nil {
+			t.Fatal(err)
+		}
+
+		for ip, pattern := range patterns {
+			switch pattern[round] {
+			case '+':
+				p.handleReply(conn.reply(t, ip, p.monotonic()))
+			case 's':
+				p.handleReply(conn.reply(t, ip, p.monotonic()+time.Second))
+			}
+		}
+		p.handleTimeouts()
+		p.checkStableGroups()
+		p.assessGroups()
+		p.seq += p.roundSeqs()
+	}
+}
+
+func TestHostStateTransitions(t *testing.T) {
+	tests := []struct {
+		name         string
+		pattern      string
+		wantUp       bool
+		wantInState  int
+		wantReceived int
+	}{
+		{"alive after alive count replies", "++", true, 2, 2},
+		{"not alive before alive count replies", "+", false, 1, 1},
+		{"dead after dead count timeouts", "++--", false, 2, 2},
+		{"single timeout keeps alive", "++-", true, 1, 2},
+		{"flapping restarts the count", "+-+", false, 1, 2},
+		{"revived after dead", "++--++", true, 2, 4},
+		{"never alive is not dead", "---", false, 2, 0},
+		{"long alive stops counting", "+++++", true, 2, 5},
+		{"send failures are ignored", "++xxx", true, 2, 2},
+		{"send failure does not break the count", "+x+", true, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, nil, "192.0.2.1")
+			runRounds(t, p, conn, map[string]string{"192.0.2.1": tt.pattern})
+
+			ri := p.send["192.0.2.1"]
+			if ri.stableIsUp != tt.wantUp {
+				t.Errorf("stableIsUp = %v, want %v", ri.stableIsUp, tt.wantUp)
+			}
+			if ri.pingsInState != tt.wantInState {
+				t.Errorf("pingsInState = %d, want %d", ri.pingsInState, tt.wantInState)
+			}
+			if ri.received != tt.wantReceived {
+				t.Errorf("received = %d, want %d", ri.received, tt.wantReceived)
+			}
+		})
+	}
+}
+
+func TestHostOptionsOverrideCounts(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1:alive=1,dead=3", "192.0.2.2")
+	runRounds(t, p, conn, map[string]string{
+		"192.0.2.1": "+--",
+		"192.0.2.2": "+--",
+	})
+
+	if !p.send["192.0.2.1"].stableIsUp {
+		t.Error("192.0.2.1 is not alive after a single reply with alive=1 and two timeouts with dead=3")
+	}
+	if p.send["192.0.2.2"].stableIsUp {
+		t.Error("192.0.2.2 is alive after a single reply with the default alive count")
+	}
+}
+
+func TestDegradedHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		pattern      string
+		wantUp       bool
+		wantDegraded bool
+	}{
+		{"fast replies", "++++", true, false},
+		{"slow replies", "ssss", true, true},
+		{"short slowdown", "++s+s+", true, false},
+		{"slowdown", "++ss", true, true},
+		{"recovered", "++ss++", true, false},
+		{"still recovering", "++ss+", true, true},
+		{"dead clears degraded", "ssss--", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, func(p *Ping) {
+				p.rttThreshold = 100 * time.Millisecond
+				p.degradedCount = 2
+			}, "192.0.2.1")
+			runRounds(t, p, conn, map[string]string{"192.0.2.1": tt.pattern})
+
+			ri := p.send["192.0.2.1"]
+			if ri.stableIsUp != tt.wantUp || ri.degraded != tt.wantDegraded {
+				t.Errorf("up %v, degraded %v, want up %v, degraded %v", ri.stableIsUp, ri.degraded, tt.wantUp, tt.wantDegraded)
+			}
+		})
+	}
+}
+
+func TestHandleReplyIgnores(t *testing.T) {
+	tests := []struct {
+		name   string
+		mangle func(p *Ping, i *icmpInfo)
+	}{
+		{"unknown host", func(p *Ping, i *icmpInfo) { i.ip = net.ParseIP("192.0.2.99") }},
+		{"foreign id", func(p *Ping, i *icmpInfo) { i.echo.ID++ }},
+		{"previous sequence", func(p *Ping, i *icmpInfo) { i.echo.Seq-- }},
+		{"stale timestamp", func(p *Ping, i *icmpInfo) { encodeTimestamp(i.echo.Data, p.roundAt-time.Second) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, nil, "192.0.2.1")
+			if err := p.sendRequests(); err != nil {
+				t.Fatal(err)
+			}
+
+			i := conn.reply(t, "192.0.2.1", p.monotonic())
+			tt.mangle(p, &i)
+			p.handleReply(i)
+
+			ri := p.send["192.0.2.1"]
+			if ri.gotReply || ri.received != 0 {
+				t.Errorf("reply accepted: gotReply = %v, received = %d", ri.gotReply, ri.received)
+			}
+		})
+	}
+}
+
+func TestHandleUnreachable(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		foreign bool
+		want    string
+	}{
+		{"host unreachable", 1, false, "host unreachable"},
+		{"administratively prohibited", 13, false, "communication administratively prohibited"},
+		{"unknown code", 99, false, "code 99"},
+		{"other process", 1, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, nil, "192.0.2.1")
+			core, logs := observer.New(zap.InfoLevel)
+			p.log = zap.New(core)
+			if err := p.sendRequests(); err != nil {
+				t.Fatal(err)
+			}
+
+			echo := conn.written["192.0.2.1"]
+			if tt.foreign {
+				echo[4]++ // the identifier
+			}
+			h := ipv4.Header{
+				Version: ipv4.Version, Len: ipv4.HeaderLen, TotalLen: ipv4.HeaderLen + len(echo),
+				TTL: 63, Protocol: protocolICMP, Src: net.ParseIP("192.0.2.100"), Dst: net.ParseIP("192.0.2.1"),
+			}
+			quoted, err := h.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wm := icmp.Message{
+				Type: ipv4.ICMPTypeDestinationUnreachable, Code: tt.code,
+				Body: &icmp.DstUnreach{Data: append(quoted, echo...)},
+			}
+			wb, err := wm.Marshal(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p.handleMessage(p.conn4, nil, wb, &net.IPAddr{IP: net.ParseIP("192.0.2.254")})
+
+			entries := logs.FilterMessage("Destination unreachable").All()
+			if tt.want == "" {
+				if len(entries) != 0 {
+					t.Errorf("logged %d unreachable errors, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("logged %d unreachable errors, want 1", len(entries))
+			}
+			if reason := entries[0].ContextMap()["reason"]; reason != tt.want {
+				t.Errorf("reason %q, want %q", reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorruptedReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    bool
+	}{
+		{"intact", func(data []byte) []byte { return data }, false},
+		{"flipped byte", func(data []byte) []byte { data[20] ^= 0xff; return data }, true},
+		{"truncated", func(data []byte) []byte { return data[:30] }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, func(p *Ping) {
+				p.payloadPattern = pattern{inc: true}
+			}, "192.0.2.1")
+			core, logs := observer.New(zap.WarnLevel)
+			p.log = zap.New(core)
+			if err := p.sendRequests(); err != nil {
+				t.Fatal(err)
+			}
+
+			i := conn.reply(t, "192.0.2.1", p.monotonic())
+			i.echo.Data = tt.corrupt(i.echo.Data)
+			p.handleReply(i)
+
+			if got := logs.FilterMessage("Corrupted reply").Len() == 1; got != tt.want {
+				t.Errorf("reported corrupted %v, want %v", got, tt.want)
+			}
+			if ri := p.send["192.0.2.1"]; ri.received != 1 {
+				t.Errorf("received %d, want the reply counted", ri.received)
+			}
+		})
+	}
+}
+
+func TestGroupStateTransitions(t *testing.T) {
+	tests := []struct {
+		name         string
+		configure    func(p *Ping)
+		patterns     map[string]string
+		wantAlive    bool
+		wantTotal    int
+		wantWasAlive bool
+	}{
+		{
+			name:         "alive when all hosts are alive",
+			patterns:     map[string]string{"192.0.2.1": "++", "192.0.2.2": "++"},
+			wantAlive:    true,
+			wantTotal:    2,
+			wantWasAlive: true,
+		},
+		{
+			name:      "not alive with a dead host",
+			patterns:  map[string]string{"192.0.2.1": "++", "192.0.2.2": "--"},
+			wantAlive: false,
+			wantTotal: 1,
+		},
+		{
+			name:         "stays alive above group dead",
+			patterns:     map[string]string{"192.0.2.1": "++--", "192.0.2.2": "++++"},
+			wantAlive:    true,
+			wantTotal:    1,
+			wantWasAlive: true,
+		},
+		{
+			name:         "dead when all hosts are dead",
+			patterns:     map[string]string{"192.0.2.1": "++--", "192.0.2.2": "++--"},
+			wantAlive:    false,
+			wantTotal:    0,
+			wantWasAlive: true,
+		},
+		{
+			name:         "group alive threshold",
+			configure:    func(p *Ping) { p.groupAlive = 1 },
+			patterns:     map[string]string{"192.0.2.1": "++", "192.0.2.2": "--"},
+			wantAlive:    true,
+			wantTotal:    1,
+			wantWasAlive: true,
+		},
+		{
+			name:         "group dead threshold",
+			configure:    func(p *Ping) { p.groupDead = 1 },
+			patterns:     map[string]string{"192.0.2.1": "++--", "192.0.2.2": "++++"},
+			wantAlive:    false,
+			wantTotal:    1,
+			wantWasAlive: true,
+		},
+		{
+			name:      "stable rounds delay the transition",
+			configure: func(p *Ping) { p.groupStableRounds = 2 },
+			patterns:  map[string]string{"192.0.2.1": "++", "192.0.2.2": "++"},
+			wantAlive: false,
+			wantTotal: 2,
+		},
+		{
+			name:         "transition after the stable rounds",
+			configure:    func(p *Ping) { p.groupStableRounds = 2 },
+			patterns:     map[string]string{"192.0.2.1": "+++", "192.0.2.2": "+++"},
+			wantAlive:    true,
+			wantTotal:    2,
+			wantWasAlive: true,
+		},
+		{
+			name:      "short excursion is not stable",
+			configure: func(p *Ping) { p.groupStableRounds, p.deadCount = 2, 1 },
+			patterns:  map[string]string{"192.0.2.1": "++-", "192.0.2.2": "+++"},
+			wantAlive: false,
+			wantTotal: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, tt.configure, "192.0.2.1", "192.0.2.2")
+			runRounds(t, p, conn, tt.patterns)
+
+			g := p.groups[0]
+			if g.isTotalAlive != tt.wantAlive {
+				t.Errorf("isTotalAlive = %v, want %v", g.isTotalAlive, tt.wantAlive)
+			}
+			if g.totalAlive != tt.wantTotal {
+				t.Errorf("totalAlive = %d, want %d", g.totalAlive, tt.wantTotal)
+			}
+			if g.wasAlive != tt.wantWasAlive {
+				t.Errorf("wasAlive = %v, want %v", g.wasAlive, tt.wantWasAlive)
+			}
+		})
+	}
+}
+
+func TestCloseStopsReceivers(t *testing.T) {
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	p.stopped = make(chan struct{})
+	p.recv(p.conn4, make(chan icmpInfo))
+
+	// nobody waits for the reply of a stopped pinger
+	p.receivers.Add(1)
+	go func() {
+		defer p.receivers.Done()
+		p.deliver(make(chan icmpInfo), icmpInfo{})
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		p.close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("receivers did not return after close")
+	}
+}
+
+func TestRecvFailed(t *testing.T) {
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	p.stopped = make(chan struct{})
+	p.failed = make(chan error, 1)
+	err := errors.New("network is down")
+
+	if !p.recvFailed(err, 1) {
+		t.Error("gave up after the first failure")
+	}
+	select {
+	case got := <-p.failed:
+		t.Fatalf("reported %v after the first failure", got)
+	default:
+	}
+
+	if p.recvFailed(err, recvMaxFailures) {
+		t.Error("kept reading after the limit of failures")
+	}
+	select {
+	case got := <-p.failed:
+		if !errors.Is(got, err) {
+			t.Errorf("reported %v, want %v", got, err)
+		}
+	default:
+		t.Error("the limit of failures was not reported")
+	}
+
+	close(p.stopped)
+	if p.recvFailed(err, recvMaxFailures-1) {
+		t.Error("kept reading after the shutdown")
+	}
+}
+
+// rawFakeConn is a fakeConn posing as a raw socket
+type rawFakeConn struct{ *fakeConn }
+
+func (c rawFakeConn) LocalAddr() net.Addr {
+	return &net.IPAddr{IP: net.IPv4zero}
+}
+
+func TestICMPIdentifier(t *testing.T) {
+	if c := newICMP4Conn(rawFakeConn{newFakeConn()}, 4321); c.pid != 4321 {
+		t.Errorf("raw socket id %d, want 4321", c.pid)
+	}
+	if c := newICMP4Conn(rawFakeConn{newFakeConn()}, 0); c.pid != uint16(os.Getpid()) {
+		t.Errorf("raw socket id %d, want the process id %d", c.pid, uint16(os.Getpid()))
+	}
+	if c := newICMP4Conn(newFakeConn(), 4321); runtime.GOOS == "linux" && c.pid != 4242 {
+		t.Errorf("unprivileged socket id %d, want the local port 4242", c.pid)
+	}
+}
+
+func TestSocketPermissionError(t *testing.T) {
+	other := errors.New("address in use")
+	if err := socketPermissionError(other, false); err != other {
+		t.Errorf("got %v, want the error unchanged", err)
+	}
+
+	denied := &os.SyscallError{Syscall: "socket", Err: os.ErrPermission}
+	for _, raw := range []bool{false, true} {
+		err := socketPermissionError(denied, raw)
+		if !errors.Is(err, os.ErrPermission) || err.Error() == denied.Error() {
+			t.Errorf("raw %v: got %v, want the explained permission error", raw, err)
+		}
+	}
+}
+
+func TestProbesPerRound(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) { p.probesPerRound = 3 }, "192.0.2.1")
+	p.seq = 65534 // the sequence numbers of the round wrap around
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+
+	ri := p.send["192.0.2.1"]
+	if ri.sent != 3 {
+		t.Fatalf("sent = %d, want 3", ri.sent)
+	}
+
+	for _, seq := range []uint16{65534, 65535, 0, 1} {
+		i := conn.reply(t, "192.0.2.1", p.monotonic())
+		i.echo.Seq = int(seq)
+		p.handleReply(i)
+	}
+
+	if ri.received != 3 {
+		t.Errorf("received = %d, want 3", ri.received)
+	}
+	if !ri.gotReply || ri.pingsInState != 1 {
+		t.Errorf("gotReply = %v, pingsInState = %d, want a single counted reply", ri.gotReply, ri.pingsInState)
+	}
+}
+
+func TestAddRemoveTarget(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1", "192.0.2.2")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++", "192.0.2.2": "++"})
+
+	g := p.groups[0]
+	if err := p.AddTarget("192.0.2.3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if ri := p.send["192.0.2.3"]; ri.stableIsUp || ri.counted {
+		t.Error("added host starts up")
+	}
+	if g.size != 3 || g.groupAlive != 3 || g.totalAlive != 2 || !g.isTotalAlive {
+		t.Errorf("after add: size %d, alive on %d, up %d, alive %v", g.size, g.groupAlive, g.totalAlive, g.isTotalAlive)
+	}
+
+	if err := p.RemoveTarget("192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if g.size != 2 || g.totalAlive != 1 || len(g.targets) != 2 {
+		t.Errorf("after remove: size %d, up %d, targets %d", g.size, g.totalAlive, len(g.targets))
+	}
+
+	runRounds(t, p, conn, map[string]string{"192.0.2.2": "--", "192.0.2.3": "--"})
+	if g.isTotalAlive || g.totalAlive != 0 {
+		t.Errorf("group alive %v with %d up after its hosts died", g.isTotalAlive, g.totalAlive)
+	}
+}
+
+func TestAddTargetMidRound(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) {
+		p.initialState = stateUnknown
+		p.deadCount = 1
+	}, "192.0.2.1")
+
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTarget("192.0.2.2", ""); err != nil {
+		t.Fatal(err)
+	}
+	p.handleTimeouts()
+	if ri := p.send["192.0.2.2"]; !ri.unknown || ri.pingsInState != 0 {
+		t.Errorf("host added mid-round charged a timeout: unknown %v, count %d", ri.unknown, ri.pingsInState)
+	}
+	p.seq += p.roundSeqs()
+
+	runRounds(t, p, conn, map[string]string{"192.0.2.2": "-"})
+	if ri := p.send["192.0.2.2"]; ri.unknown || ri.stableIsUp {
+		t.Errorf("host not dead after its first ping timed out: unknown %v, up %v", ri.unknown, ri.stableIsUp)
+	}
+}
+
+func TestZonedTargets(t *testing.T) {
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	conn := newFakeConn()
+	p.conn6 = newICMP6Conn(conn, uint16(p.icmpID))
+
+	// the same link-local address on two links is two hosts
+	for _, arg := range []string{"fe80::1%65534", "fe80::1%65535"} {
+		if err := p.AddTarget(arg, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(p.send) != 3 {
+		t.Fatalf("got %d hosts, want 3", len(p.send))
+	}
+
+	runRounds(t, p, conn, map[string]string{"fe80::1%65534": "++", "fe80::1%65535": "--"})
+	if ri := p.send["fe80::1%65534"]; !ri.stableIsUp || ri.received != 2 {
+		t.Errorf("replying host up %v with %d received", ri.stableIsUp, ri.received)
+	}
+	if ri := p.send["fe80::1%65535"]; ri.stableIsUp || ri.received != 0 {
+		t.Errorf("silent host up %v with %d received", ri.stableIsUp, ri.received)
+	}
+
+	if err := p.RemoveTarget("fe80::1%65535"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.send["fe80::1%65534"]; !ok || len(p.send) != 2 {
+		t.Errorf("removing one zone left %d hosts", len(p.send))
+	}
+}
+
+func TestSendRate(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) { p.sendRate = 100 }, "192.0.2.0/29")
+
+	start := time.Now()
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first request goes right away, the other five at 10ms intervals
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("sent 6 requests in %s", elapsed)
+	}
+	if len(conn.written) != 6 {
+		t.Errorf("sent to %d hosts, want 6", len(conn.written))
+	}
+}
+
+func TestSendRateReleasesLock(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) { p.sendRate = 20 }, "192.0.2.0/29")
+
+	// the pacing takes 250ms, the status is served meanwhile
+	locked := make(chan time.Duration)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		p.mu.Lock()
+		p.mu.Unlock()
+		locked <- time.Since(start)
+	}()
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+	if waited := <-locked; waited > 100*time.Millisecond {
+		t.Errorf("waited %s for the lock while sending", waited)
+	}
+
+	if ri := p.send["192.0.2.1"]; len(conn.written) != 6 || ri.sent != 1 || ri.sendFailed {
+		t.Errorf("sent to %d hosts, %d to the first one, failed %v", len(conn.written), ri.sent, ri.sendFailed)
+	}
+}
+
+func TestRoundSummary(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++", "192.0.2.2": "++", "192.0.2.3": "--"})
+
+	core, logs := observer.New(zap.InfoLevel)
+	p.log = zap.New(core)
+	p.logRoundSummary(2)
+
+	entries := logs.FilterMessage("Round summary").All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d summaries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["round"] != int64(2) || fields["up"] != "2/3" || fields["group"] != stateDead {
+		t.Errorf("got summary %v", fields)
+	}
+}
+
+func TestBroadcastReplies(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) { p.allowBroadcast = true }, "224.0.0.1", "192.0.2.1")
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the responders reply from their own addresses, the first one answers the round
+	for _, from := range []string{"192.0.2.7", "192.0.2.8"} {
+		i := conn.reply(t, "224.0.0.1", p.monotonic())
+		i.ip = net.ParseIP(from)
+		p.handleReply(i)
+	}
+
+	if ri := p.send["224.0.0.1"]; !ri.broadcast || !ri.gotReply || ri.received != 1 {
+		t.Errorf("broadcast %v, got reply %v, received %d, want a single counted reply", ri.broadcast, ri.gotReply, ri.received)
+	}
+	if ri := p.send["192.0.2.1"]; ri.broadcast || ri.gotReply {
+		t.Error("the unicast host took the reply to the broadcast")
+	}
+}
+
+func TestFirstReply(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1", "192.0.2.2")
+	core, logs := observer.New(zap.InfoLevel)
+	p.log = zap.New(core)
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "-++", "192.0.2.2": "---"})
+
+	entries := logs.FilterMessage("First reply").All()
+	if len(entries) != 1 || entries[0].ContextMap()["ip"] != "192.0.2.1" {
+		t.Errorf("got first replies %v, want one of 192.0.2.1", entries)
+	}
+}
+
+func TestRecvBuffer(t *testing.T) {
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	if got := p.recvBufferSize(); got != minRecvBuffer {
+		t.Errorf("default buffer %d, want %d", got, minRecvBuffer)
+	}
+	p.payloadSize = 8000
+	if got := p.recvBufferSize(); got != maxIPHeaderSize+icmpHeaderSize+8000 {
+		t.Errorf("buffer %d does not fit the payload", got)
+	}
+	p.recvBuffer = 9000
+	if got := p.recvBufferSize(); got != 9000 {
+		t.Errorf("buffer %d, want the configured 9000", got)
+	}
+	p.recvBuffer = 1500
+	if p.validate() == nil {
+		t.Error("accepted a buffer not fitting the payload")
+	}
+
+	core, logs := observer.New(zap.WarnLevel)
+	p.log = zap.New(core)
+	c := p.conn4
+	for _, n := range []int{100, 9000, 9000} {
+		p.checkTruncated(c, n, 9000)
+	}
+	if got := logs.Len(); got != 1 {
+		t.Errorf("warned %d times, want once", got)
+	}
+}
+
+func TestHostPause(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1:pause=1h", "192.0.2.2:pause=2s", "192.0.2.3")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "+--", "192.0.2.2": "+++", "192.0.2.3": "+--"})
+
+	// the rounds go every shortest pause, the other hosts wait for their own
+	if p.tick != 2*time.Second {
+		t.Errorf("rounds every %s, want 2s", p.tick)
+	}
+	for ip, want := range map[string]int{"192.0.2.1": 1, "192.0.2.2": 3, "192.0.2.3": 1} {
+		ri := p.send[ip]
+		if ri.sent != want || !ri.isUp {
+			t.Errorf("%s: sent %d, up %v, want %d pings without timeouts", ip, ri.sent, ri.isUp, want)
+		}
+	}
+
+	if p.checkHostOptions([]target{{ip: net.ParseIP("192.0.2.4"), opts: targetOptions{pause: p.waitTimeout}}}) == nil {
+		t.Error("accepted a host pause not longer than the wait")
+	}
+}
+
+func TestMatchIDOnly(t *testing.T) {
+	for _, matchIDOnly := range []bool{false, true} {
+		p, conn := newTestPing(t, func(p *Ping) {
+			p.matchIDOnly = matchIDOnly
+			p.probesPerRound = 2
+		}, "192.0.2.1", "192.0.2.2")
+
+		for range 2 {
+			p.seq += p.roundSeqs()
+			if err := p.sendRequests(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the NAT replies for the second host only
+			i := conn.reply(t, "192.0.2.2", p.monotonic())
+			i.ip = net.ParseIP("198.51.100.1")
+			p.handleReply(i)
+		}
+
+		if got := p.send["192.0.2.2"].received; got != map[bool]int{false: 0, true: 2}[matchIDOnly] {
+			t.Errorf("match id only %v: received %d replies from the other address", matchIDOnly, got)
+		}
+		if p.send["192.0.2.1"].received != 0 {
+			t.Errorf("match id only %v: the reply is attributed to the wrong host", matchIDOnly)
+		}
+	}
+}
+
+func TestLastChange(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1")
+	core, logs := observer.New(zap.InfoLevel)
+	p.log = zap.New(core)
+	ri := p.send["192.0.2.1"]
+	ri.lastChange = time.Now().Add(-time.Minute)
+
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "++"})
+	if time.Since(ri.lastChange) > time.Second {
+		t.Errorf("last change %v is not the time the host became alive", ri.lastChange)
+	}
+	entries := logs.FilterMessage("Remote host is alive").All()
+	if len(entries) != 1 || entries[0].ContextMap()["after"] != time.Minute {
+		t.Errorf("got %v, want the host alive after a minute", entries)
+	}
+
+	if s := p.snapshot(); s.Hosts[0].LastChange != ri.lastChange.Format(time.RFC3339) || s.Hosts[0].InState > 1 {
+		t.Errorf("status %+v does not show the last change", s.Hosts[0])
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	p, conn := newTestPing(t, nil, "192.0.2.1", "192.0.2.2")
+	conn.nobufs["192.0.2.1"] = sendRetries
+	conn.nobufs["192.0.2.2"] = sendRetries + 1
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ri := p.send["192.0.2.1"]; ri.sendFailed || ri.sent != 1 {
+		t.Error("the request is not sent on a retry")
+	}
+	if ri := p.send["192.0.2.2"]; !ri.sendFailed {
+		t.Error("the request failing more than the retries is not given up")
+	}
+}
+
+func TestPartialStack(t *testing.T) {
+	failing := func(family string) func(network string) (packetConn, error) {
+		return func(network string) (packetConn, error) {
+			if network == family {
+				return nil, errors.New("address family not supported")
+			}
+			return newFakeConn(), nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		partial bool
+		hosts   []string
+		failing string
+		wantErr bool
+		want4   bool
+		want6   bool
+	}{
+		{"IPv6 failing", false, []string{"192.0.2.1", "2001:db8::1"}, "udp6", true, false, false},
+		{"IPv6 skipped", true, []string{"192.0.2.1", "2001:db8::1"}, "udp6", false, true, false},
+		{"IPv4 skipped", true, []string{"192.0.2.1", "2001:db8::1"}, "udp4", false, false, true},
+		{"nothing left", true, []string{"2001:db8::1"}, "udp6", true, false, false},
+		{"not needed", false, []string{"192.0.2.1"}, "udp6", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Ping{log: zap.NewNop(), partialStack: tt.partial}
+			for _, host := range tt.hosts {
+				targets, err := parseTarget(host)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.targets = append(p.targets, targets...)
+			}
+
+			conn4, conn6, err := p.openSockets(failing(tt.failing))
+			if (err != nil) != tt.wantErr || (conn4 != nil) != tt.want4 || (conn6 != nil) != tt.want6 {
+				t.Errorf("got IPv4 %v, IPv6 %v, error %v", conn4 != nil, conn6 != nil, err)
+			}
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		window  int
+		wantUp  bool
+	}{
+		{"lossy link never alive in a row", "+-+-+-+-", 0, false},
+		{"lossy link alive in the window", "+-+-+-+-", 4, true},
+		{"too lossy for the window", "+--+--+--", 4, false},
+		{"dead on the timeouts in the window", "++-+--", 4, false},
+		{"revived in the window", "++---+-+", 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, conn := newTestPing(t, func(p *Ping) {
+				p.window = tt.window
+				p.deadCount = 3
+			}, "192.0.2.1")
+			runRounds(t, p, conn, map[string]string{"192.0.2.1": tt.pattern})
+
+			if ri := p.send["192.0.2.1"]; ri.stableIsUp != tt.wantUp {
+				t.Errorf("stableIsUp = %v, want %v", ri.stableIsUp, tt.wantUp)
+			}
+		})
+	}
+}
+
+func TestDuplicateAndReorderedReplies(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) { p.probesPerRound = 3 }, "192.0.2.1")
+	core, logs := observer.New(zap.InfoLevel)
+	p.log = zap.New(core)
+	if err := p.sendRequests(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, probe := range []int{2, 0, 0, 1} {
+		i := conn.reply(t, "192.0.2.1", p.monotonic())
+		i.echo.Seq = int(p.seq) + probe
+		p.handleReply(i)
+	}
+
+	if n := logs.FilterMessage("Duplicate reply").Len(); n != 1 {
+		t.Errorf("got %d duplicates, want 1", n)
+	}
+	if n := logs.FilterMessage("Reply out of order").Len(); n != 2 {
+		t.Errorf("got %d replies out of order, want 2", n)
+	}
+	if ri := p.send["192.0.2.1"]; ri.received != 3 {
+		t.Errorf("received %d replies, want the duplicate not counted", ri.received)
+	}
+}
+
+func TestECMPProbe(t *testing.T) {
+	p, conn := newTestPing(t, func(p *Ping) { p.ecmpProbe = 2 }, "192.0.2.1")
+	other := newFakeConn()
+	p.ecmp4 = []*icmpConn{newICMP4Conn(other, p.conn4.pid+1)}
+	if got := len(p.conns()); got != 2 {
+		t.Fatalf("got %d sockets, want 2", got)
+	}
+
+	ri := p.send["192.0.2.1"]
+	for round, want := range []*fakeConn{conn, other, conn} {
+		p.seq += p.roundSeqs()
+		if err := p.sendRequests(); err != nil {
+			t.Fatal(err)
+		}
+		if ri.conn.conn != want {
+			t.Errorf("round %d sent from the wrong socket", round+1)
+		}
+		p.handleReply(want.reply(t, "192.0.2.1", p.monotonic()))
+		if !ri.gotReply {
+			t.Errorf("round %d: the reply to the socket is not matched", round+1)
+		}
+	}
+}
+
+func TestRetryBind(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []error
+		wantErr   bool
+		wantTries int
+	}{
+		{"opened at once", nil, false, 1},
+		{"opened on a retry", []error{syscall.ENODEV}, false, 2},
+		{"out of retries", []error{syscall.ENODEV, syscall.ENODEV}, true, 2},
+		{"permission is final", []error{os.ErrPermission}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Ping{log: zap.NewNop(), retryBind: 1}
+			tries := 0
+			listen := p.retryListen(func(string) (packetConn, error) {
+				tries++
+				if tries <= len(tt.failures) {
+					return nil, tt.failures[tries-1]
+				}
+				return newFakeConn(), nil
+			})
+
+			if _, err := listen("udp4"); (err != nil) != tt.wantErr || tries != tt.wantTries {
+				t.Errorf("got error %v after %d tries, want %d", err, tries, tt.wantTries)
+			}
+		})
+	}
+}
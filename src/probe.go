@@ -0,0 +1,104 @@
+package src
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// probeMode returns whether the hosts are probed by other means than
+// ICMP echo, which need no ICMP sockets
+func (p *Ping) probeMode() bool {
+	return p.tcpPort > 0 || p.httpURL != ""
+}
+
+// probeTCP connects to the port of the host in the background,
+// a successful connection is delivered as an echo reply of the round
+func (p *Ping) probeTCP(ri *remoteInfo, seq uint16) {
+	addr := net.JoinHostPort(ri.address(), strconv.Itoa(p.tcpPort))
+	reply := p.probeReply(ri, seq)
+	timeout := p.waitTimeout
+
+	go func() {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			p.log.Debug("TCP probe failed", zap.String("address", addr), zap.Error(err))
+			return
+		}
+		_ = conn.Close()
+
+		reply.received = p.monotonic()
+		p.deliver(p.replies, reply)
+	}()
+}
+
+// probeHTTP requests the url of the host in the background, a response
+// with the expected status is delivered as an echo reply of the round
+func (p *Ping) probeHTTP(ri *remoteInfo, seq uint16) {
+	ip := ri.address()
+	host := ip
+	if ri.ip.To4() == nil {
+		// the zone separator is escaped in the urls
+		host = "[" + strings.Replace(ip, "%", "%25", 1) + "]"
+	}
+	url := strings.ReplaceAll(p.httpURL, "{ip}", host)
+	reply := p.probeReply(ri, seq)
+
+	// without {ip} in the url, connect to the host keeping the url one for the request and TLS
+	var dialer net.Dialer
+	transport := &http.Transport{DisableKeepAlives: true}
+	if url == p.httpURL {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		}
+	}
+	client := &http.Client{Timeout: p.waitTimeout, Transport: transport}
+	expect := p.httpExpect
+
+	go func() {
+		resp, err := client.Get(url)
+		if err != nil {
+			p.log.Debug("HTTP probe failed", zap.String("url", url), zap.Error(err))
+			return
+		}
+		_ = resp.Body.Close()
+
+		if !expectedStatus(resp.StatusCode, expect) {
+			p.log.Debug("HTTP probe got unexpected status", zap.String("url", url), zap.Int("status", resp.StatusCode))
+			return
+		}
+
+		reply.received = p.monotonic()
+		p.deliver(p.replies, reply)
+	}()
+}
+
+// expectedStatus returns whether the status is the expected one, any 2xx if it is 0
+func expectedStatus(status, expect int) bool {
+	if expect == 0 {
+		return status >= 200 && status < 300
+	}
+	return status == expect
+}
+
+// probeReply returns the echo reply matching the probe of the host
+func (p *Ping) probeReply(ri *remoteInfo, seq uint16) icmpInfo {
+	data := make([]byte, timestampSize)
+	encodeTimestamp(data, p.monotonic())
+
+	return icmpInfo{
+		ip: ri.ip,
+		echo: icmp.Echo{
+			Seq:  int(seq),
+			Data: data,
+		},
+	}
+}
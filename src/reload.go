@@ -0,0 +1,216 @@
+package src
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+)
+
+// reload re-reads the config and the targets, keeping the state of the hosts
+// and the groups which are still configured. The options are parsed into a
+// scratch pinger and swapped in at once, the options applied when the sockets
+// and the servers are opened only change on restart.
+func (p *Ping) reload() {
+	p.log.Info("Reloading the config")
+
+	next := &Ping{log: p.log}
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	generalOptions, pingOptions, groupOptions := next.flagSets(&logOptions{})
+	flags.AddFlagSet(generalOptions)
+	flags.AddFlagSet(pingOptions)
+	flags.AddFlagSet(groupOptions)
+
+	var targets []target
+	var groups []*group
+	err := flags.Parse(os.Args[1:])
+	if err == nil {
+		targets, groups, err = next.loadTargets(flags, flags.Args())
+	}
+	if err == nil {
+		err = next.validate()
+	}
+	if err != nil {
+		p.log.Error("Failed to reload the config", zap.Error(err))
+		return
+	}
+
+	fixed, wanted := p.fixedOptions(), next.fixedOptions()
+	for _, name := range slices.Sorted(maps.Keys(fixed)) {
+		if !reflect.DeepEqual(fixed[name], wanted[name]) {
+			p.log.Warn("Option only changes on restart", zap.String("option", name),
+				zap.String("value", redactOption(name, fmt.Sprint(fixed[name]))),
+				zap.String("wanted", redactOption(name, fmt.Sprint(wanted[name]))))
+		}
+	}
+
+	next.warnDuplicates(targets, groups)
+	groups = next.allGroups(targets, groups)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.applyOptions(next)
+
+	// reuse the groups of the same name to keep their state
+	current := make(map[string]*group)
+	for _, g := range p.groups {
+		current[g.name] = g
+	}
+	for i, g := range groups {
+		if c, ok := current[g.name]; ok {
+			c.groupAlive, c.groupDead, c.allAlive = g.groupAlive, g.groupDead, false
+			c.mode = g.mode
+			c.cmdAlive, c.cmdDead = g.cmdAlive, g.cmdDead
+			c.initialCommand = g.initialCommand
+			c.targets = g.targets
+			groups[i] = c
+		}
+	}
+
+	added, removed, kept := 0, 0, 0
+	wantedHosts := make(map[string]bool)
+	for _, g := range groups {
+		for _, t := range g.targets {
+			key := t.address()
+			wantedHosts[key] = true
+
+			if ri, ok := p.send[key]; ok {
+				ri.moveToGroup(g)
+				p.applyTargetOptions(ri, t)
+				if ri.label != t.label {
+					// the label is a part of the metric series identity
+					p.metrics.forget(ri)
+					ri.label = t.label
+					p.metrics.setUp(ri)
+				}
+				kept++
+				continue
+			}
+
+			p.addTarget(g, t)
+			if ri, ok := p.send[key]; ok {
+				p.metrics.setUp(ri)
+				added++
+			}
+		}
+	}
+
+	for key, ri := range p.send {
+		if !wantedHosts[key] {
+			p.log.Info("Removing host", zap.Stringer("ip", ri))
+			p.removeTarget(ri)
+			removed++
+		}
+	}
+
+	p.groups = groups
+	p.updateGroups()
+
+	// the thresholds and the members may have changed
+	p.settleGroups()
+	p.lookupPTRs()
+
+	p.log.Info("Reloaded the config",
+		zap.Int("added", added),
+		zap.Int("removed", removed),
+		zap.Int("kept", kept),
+		zap.Int("groups", len(p.groups)))
+}
+
+// fixedOptions returns the options applied when the sockets and the servers are
+// opened by their flag names
+func (p *Ping) fixedOptions() map[string]any {
+	return map[string]any{
+		"payload-size":   p.payloadSize,
+		"recv-buffer":    p.recvBuffer,
+		"ttl":            p.ttl,
+		"tos":            p.tos,
+		"dscp":           p.dscp,
+		"dont-fragment":  p.dontFragment,
+		"interface":      p.iface,
+		"source":         p.source,
+		"raw":            p.raw,
+		"partial-stack":  p.partialStack,
+		"retry-bind":     p.retryBind,
+		"ecmp-probe":     p.ecmpProbe,
+		"icmp-id":        p.icmpID,
+		"tcp-port":       p.tcpPort,
+		"http-url":       p.httpURL,
+		"metrics-addr":   p.metricsAddr,
+		"status-addr":    p.statusAddr,
+		"influx-url":     p.influxURL,
+		"influx-bucket":  p.influxBucket,
+		"influx-org":     p.influxOrg,
+		"influx-token":   p.influxToken,
+		"statsd-addr":    p.statsdAddr,
+		"csv-file":       p.csvFile,
+		"state-file":     p.stateFile,
+		"control-socket": p.controlSocket,
+		"events":         p.writeEvents,
+	}
+}
+
+// applyOptions takes the options of the reloaded pinger, but the fixed ones
+func (p *Ping) applyOptions(next *Ping) {
+	p.waitTimeout = next.waitTimeout
+	p.pauseDuration = next.pauseDuration
+	p.minPause = next.minPause
+	p.jitter = next.jitter
+	p.aliveCount = next.aliveCount
+	p.deadCount = next.deadCount
+	p.window = next.window
+	p.initialState = next.initialState
+	p.groupAlive = next.groupAlive
+	p.groupDead = next.groupDead
+	p.groupMode = next.groupMode
+	p.groupStableRounds = next.groupStableRounds
+	p.cmdAlive = next.cmdAlive
+	p.cmdDead = next.cmdDead
+	p.configFile = next.configFile
+	p.targetLists = next.targetLists
+	p.targetsFile = next.targetsFile
+	p.groupsFile = next.groupsFile
+	p.strict = next.strict
+	p.cmdHostAlive = next.cmdHostAlive
+	p.cmdHostDead = next.cmdHostDead
+	p.cmdDegraded = next.cmdDegraded
+	p.rttThreshold = next.rttThreshold
+	p.degradedCount = next.degradedCount
+	p.cmdTimeout = next.cmdTimeout
+	p.initialCommand = next.initialCommand
+	p.cmdCooldown = next.cmdCooldown
+	p.aliveWebhook = next.aliveWebhook
+	p.deadWebhook = next.deadWebhook
+	p.cmdHeartbeat = next.cmdHeartbeat
+	p.heartbeatEvery = next.heartbeatEvery
+	p.resolvePTR = next.resolvePTR
+	p.resolveEvery = next.resolveEvery
+	p.resolveMinEvery = next.resolveMinEvery
+	p.allowEmpty = next.allowEmpty
+	p.probesPerRound = next.probesPerRound
+	p.sendConcurrency = next.sendConcurrency
+	p.payloadPattern = next.payloadPattern
+	p.count = next.count
+	p.startupTimeout = next.startupTimeout
+	p.exitCode = next.exitCode
+	p.roundSummary = next.roundSummary
+	p.showConfig = next.showConfig
+	p.allowBroadcast = next.allowBroadcast
+	p.matchIDOnly = next.matchIDOnly
+	p.httpExpect = next.httpExpect
+	p.pushgatewayURL = next.pushgatewayURL
+
+	if next.sendRate != p.sendRate {
+		p.sendRate, p.limiter = next.sendRate, nil
+		if p.sendRate > 0 {
+			p.limiter = rate.NewLimiter(rate.Limit(p.sendRate), 1)
+		}
+	}
+}
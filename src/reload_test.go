@@ -0,0 +1,75 @@
+package src
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "pinger.yaml")
+	content := "wait: 2s\npause: 10s\npayload-size: 1000\ntargets: [192.0.2.1, 192.0.2.2]\n"
+	if err := os.WriteFile(config, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := os.Args
+	os.Args = []string{"pinger", "--config", config}
+	t.Cleanup(func() { os.Args = args })
+
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	core, logs := observer.New(zap.WarnLevel)
+	p.log = zap.New(core)
+
+	// the control socket reads the options while they are reloaded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			if p.AddTarget("192.0.2.3:pause=3s", "") == nil {
+				_ = p.RemoveTarget("192.0.2.3")
+			}
+		}
+	}()
+	p.reload()
+	<-done
+
+	if p.waitTimeout != 2*time.Second || p.pauseDuration != 10*time.Second {
+		t.Errorf("wait %s and pause %s not reloaded", p.waitTimeout, p.pauseDuration)
+	}
+	if _, ok := p.send["192.0.2.2"]; !ok {
+		t.Error("added host not pinged")
+	}
+
+	// the receive buffer is sized for the payload when the sockets are opened
+	if p.payloadSize != 56 {
+		t.Errorf("payload size = %d, want 56 until restart", p.payloadSize)
+	}
+	if logs.FilterMessage("Option only changes on restart").FilterField(zap.String("option", "payload-size")).Len() != 1 {
+		t.Errorf("no warning about the payload size, got %v", logs.All())
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "pinger.yaml")
+	content := "wait: 20s\ntargets: [192.0.2.2]\n"
+	if err := os.WriteFile(config, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := os.Args
+	os.Args = []string{"pinger", "--config", config}
+	t.Cleanup(func() { os.Args = args })
+
+	p, _ := newTestPing(t, nil, "192.0.2.1")
+	p.reload()
+
+	// the wait longer than the pause is rejected as a whole
+	if p.waitTimeout != time.Second {
+		t.Errorf("wait = %s, want the old one", p.waitTimeout)
+	}
+	if _, ok := p.send["192.0.2.2"]; ok || len(p.send) != 1 {
+		t.Errorf("hosts changed by the invalid config: %d", len(p.send))
+	}
+}
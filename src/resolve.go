@@ -0,0 +1,193 @@
+package src
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"time"
+)
+
+// resolveLoop periodically re-resolves the hostname-backed targets. The resolver
+// does not tell the TTL of the records, so the interval is fixed, but a host going
+// dead has its name re-resolved early as its address may have moved. Either way
+// a name is not looked up more often than the min interval.
+func (p *Ping) resolveLoop(done chan struct{}) {
+	ticker := time.NewTicker(p.resolveEvery)
+	defer ticker.Stop()
+
+	for {
+		var names []string
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			names = p.hostnames()
+		case name := <-p.resolveNow:
+			names = []string{name}
+		}
+
+		for _, name := range names {
+			if !p.resolveDue(name, time.Now()) {
+				continue
+			}
+			ips, err := net.LookupIP(name)
+			if err != nil {
+				p.log.Warn("Failed to re-resolve host", zap.String("name", name), zap.Error(err))
+				continue
+			}
+			p.updateAddresses(name, ips)
+		}
+	}
+}
+
+// resolveDue returns whether the name may be looked up at the time, recording the lookup if so
+func (p *Ping) resolveDue(name string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if last, ok := p.resolvedAt[name]; ok && now.Sub(last) < p.resolveMinEvery {
+		p.log.Debug("Skipping the re-resolution within the min interval", zap.String("name", name))
+		return false
+	}
+	p.resolvedAt[name] = now
+	return true
+}
+
+// resolveEarly asks for the name of the dead host to be re-resolved before the next interval
+func (p *Ping) resolveEarly(ri *remoteInfo) {
+	if ri.name == "" || p.resolveEvery == 0 {
+		return
+	}
+	select {
+	case p.resolveNow <- ri.name:
+	default:
+	}
+}
+
+func (p *Ping) hostnames() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, ri := range p.send {
+		if ri.name != "" && !seen[ri.name] {
+			seen[ri.name] = true
+			names = append(names, ri.name)
+		}
+	}
+
+	return names
+}
+
+// updateAddresses moves the targets of the hostname whose address disappeared
+// from the resolver answer to the newly appeared addresses
+func (p *Ping) updateAddresses(name string, ips []net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resolved := make(map[string]bool)
+	var fresh []net.IP
+	for _, ip := range ips {
+		resolved[ip.String()] = true
+		if _, taken := p.send[ip.String()]; !taken && p.connFor(ip) != nil {
+			fresh = append(fresh, ip)
+		}
+	}
+
+	var stale []string
+	for key, ri := range p.send {
+		if ri.name == name && !resolved[key] {
+			stale = append(stale, key)
+		}
+	}
+
+	for _, key := range stale {
+		if len(fresh) == 0 {
+			p.log.Debug("Remote host address is gone", zap.Stringer("ip", p.send[key]))
+			continue
+		}
+
+		ri, ip := p.send[key], fresh[0]
+		fresh = fresh[1:]
+
+		p.log.Info("Remote host address changed",
+			zap.String("name", name),
+			zap.Stringer("from", ri.ip),
+			zap.Stringer("to", ip))
+
+		delete(p.send, key)
+		p.metrics.forget(ri)
+		ri.ip = ip
+		ri.conn = p.connFor(ip)
+		ri.addr = ri.conn.remoteAddr(ip, ri.zone)
+		ri.pingsInState = 0
+		ri.window.reset()
+		p.send[ri.address()] = ri
+		p.metrics.setUp(ri)
+	}
+}
+
+const (
+	ptrTimeout     = 5 * time.Second // limit of a reverse lookup
+	ptrConcurrency = 8               // reverse lookups in flight
+)
+
+// lookupPTRs starts looking up the names of the numeric hosts in the background,
+// for the logs only. The answers, including the failures, are cached so that
+// every address is looked up once. Called with the pinger locked.
+func (p *Ping) lookupPTRs() {
+	if !p.resolvePTR {
+		return
+	}
+
+	var ips []string
+	for key, ri := range p.send {
+		if ri.name != "" || ri.ptr != "" {
+			continue
+		}
+		if name, ok := p.ptrCache[key]; ok {
+			ri.ptr = name
+			continue
+		}
+		ips = append(ips, key)
+	}
+
+	go func() {
+		sem := make(chan struct{}, ptrConcurrency)
+		for _, ip := range ips {
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem }()
+				p.setPTR(ip, lookupPTR(ip))
+			}()
+		}
+	}()
+}
+
+// lookupPTR returns the first name of the ip, empty if it has none
+func lookupPTR(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), ptrTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// setPTR caches the name of the ip and gives it to the host
+func (p *Ping) setPTR(ip, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ptrCache[ip] = name
+	if ri, ok := p.send[ip]; ok && ri.name == "" {
+		ri.ptr = name
+	}
+	if name != "" {
+		p.log.Debug("Resolved host name", zap.String("ip", ip), zap.String("name", name))
+	}
+}
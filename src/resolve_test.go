@@ -0,0 +1,64 @@
+package src
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPTRNames(t *testing.T) {
+	p, _ := newTestPing(t, func(p *Ping) { p.resolvePTR = true }, "192.0.2.1", "192.0.2.2=core", "192.0.2.3")
+
+	// the cached answers apply right away
+	p.ptrCache["192.0.2.1"] = "edge.example.net"
+	p.ptrCache["192.0.2.2"] = ""
+	p.ptrCache["192.0.2.3"] = ""
+	p.lookupPTRs()
+	if got := p.send["192.0.2.1"].String(); got != "edge.example.net (192.0.2.1)" {
+		t.Errorf("got %s from the cache", got)
+	}
+
+	// the label takes precedence over the looked up name
+	p.setPTR("192.0.2.2", "core.example.net")
+	if got := p.send["192.0.2.2"].String(); got != "core (192.0.2.2)" {
+		t.Errorf("got %s for the labeled host", got)
+	}
+
+	if got := p.send["192.0.2.3"].String(); got != "192.0.2.3" {
+		t.Errorf("got %s for the host without a name", got)
+	}
+}
+
+func TestResolveMinInterval(t *testing.T) {
+	p, _ := newTestPing(t, func(p *Ping) {
+		p.resolveEvery = time.Minute
+		p.resolveMinEvery = 10 * time.Second
+	}, "192.0.2.1")
+
+	now := time.Now()
+	for _, tt := range []struct {
+		name  string
+		after time.Duration
+		want  bool
+	}{
+		{"a.example.net", 0, true},
+		{"a.example.net", 5 * time.Second, false},
+		{"b.example.net", 5 * time.Second, true},
+		{"a.example.net", 10 * time.Second, true},
+		{"a.example.net", 15 * time.Second, false},
+	} {
+		if got := p.resolveDue(tt.name, now.Add(tt.after)); got != tt.want {
+			t.Errorf("%s after %s: due %v, want %v", tt.name, tt.after, got, tt.want)
+		}
+	}
+
+	// the early re-resolutions are asked for the hostnames only, without blocking
+	ri := p.send["192.0.2.1"]
+	p.resolveEarly(ri)
+	ri.name = "c.example.net"
+	for range cap(p.resolveNow) + 1 {
+		p.resolveEarly(ri)
+	}
+	if len(p.resolveNow) != cap(p.resolveNow) || <-p.resolveNow != "c.example.net" {
+		t.Errorf("got %d early re-resolutions", len(p.resolveNow))
+	}
+}
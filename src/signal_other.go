@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package src
+
+import "os"
+
+// notifyToggle relays the signal toggling the log level, there is none on this platform
+func notifyToggle(chan os.Signal) {}
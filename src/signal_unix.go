@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package src
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyToggle relays the signal toggling the log level, SIGUSR1
+func notifyToggle(ch chan os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
@@ -0,0 +1,149 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// savedState is the state of the hosts and the groups kept across restarts
+type savedState struct {
+	Time   time.Time    `json:"timestamp"`
+	Hosts  []savedHost  `json:"hosts"`
+	Groups []savedGroup `json:"groups"`
+}
+
+type savedHost struct {
+	IP           string `json:"ip"`
+	Up           bool   `json:"up"`       // the confirmed state
+	Replying     bool   `json:"replying"` // the state of the last pings
+	PingsInState int    `json:"pings_in_state"`
+}
+
+type savedGroup struct {
+	Name       string `json:"name"`
+	Alive      bool   `json:"alive"`
+	WasAlive   bool   `json:"was_alive"`
+	FiredState string `json:"fired_state,omitempty"`
+}
+
+// saveState writes the state to the state file, replacing it atomically
+func (p *Ping) saveState() {
+	if p.stateFile == "" {
+		return
+	}
+
+	p.mu.Lock()
+	s := savedState{Time: time.Now()}
+	for key, ri := range p.send {
+		s.Hosts = append(s.Hosts, savedHost{
+			IP:           key,
+			Up:           ri.stableIsUp,
+			Replying:     ri.isUp,
+			PingsInState: ri.pingsInState,
+		})
+	}
+	for _, g := range p.groups {
+		s.Groups = append(s.Groups, savedGroup{
+			Name:       g.name,
+			Alive:      g.isTotalAlive,
+			WasAlive:   g.wasAlive,
+			FiredState: g.firedState,
+		})
+	}
+	p.mu.Unlock()
+	sort.Slice(s.Hosts, func(i, j int) bool { return s.Hosts[i].IP < s.Hosts[j].IP })
+
+	if err := writeState(p.stateFile, s); err != nil {
+		p.log.Error("Failed to save the state", zap.String("path", p.stateFile), zap.Error(err))
+		return
+	}
+	p.log.Info("Saved the state", zap.String("path", p.stateFile))
+}
+
+func writeState(path string, s savedState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreState applies the saved state to the hosts and the groups which are
+// still configured, so a restart neither re-warms them nor fires the commands again
+func (p *Ping) restoreState() {
+	if p.stateFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(p.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		p.log.Info("No saved state", zap.String("path", p.stateFile))
+		return
+	}
+	var s savedState
+	if err == nil {
+		err = json.Unmarshal(data, &s)
+	}
+	if err != nil {
+		p.log.Warn("Failed to restore the state", zap.String("path", p.stateFile), zap.Error(err))
+		return
+	}
+
+	hosts := 0
+	for _, h := range s.Hosts {
+		ri, ok := p.send[h.IP]
+		if !ok {
+			continue
+		}
+		ri.isUp, ri.stableIsUp, ri.pingsInState = h.Replying, h.Up, h.PingsInState
+		ri.group.known(ri)
+		if ri.stableIsUp && !ri.counted {
+			ri.counted = true
+			ri.group.count(ri)
+		}
+		hosts++
+	}
+
+	groups := make(map[string]*group)
+	for _, g := range p.groups {
+		groups[g.name] = g
+	}
+	restored := 0
+	for _, sg := range s.Groups {
+		g, ok := groups[sg.Name]
+		if !ok {
+			continue
+		}
+		g.isTotalAlive, g.wasAlive, g.firedState = sg.Alive, sg.WasAlive, sg.FiredState
+		g.assessed = true
+		restored++
+	}
+
+	p.log.Info("Restored the state",
+		zap.String("path", p.stateFile),
+		zap.String("saved", s.Time.Format(time.RFC3339)),
+		zap.Int("hosts", hosts),
+		zap.Int("groups", restored))
+
+	// the configuration may have changed since the state was saved
+	p.settleGroups()
+}
@@ -0,0 +1,55 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	configure := func(p *Ping) { p.stateFile = path }
+
+	p, conn := newTestPing(t, configure, "192.0.2.1", "192.0.2.2")
+	runRounds(t, p, conn, map[string]string{"192.0.2.1": "+++", "192.0.2.2": "++-"})
+	p.saveState()
+
+	restored, _ := newTestPing(t, configure, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	for ip, want := range map[string]*remoteInfo{
+		"192.0.2.1": {stableIsUp: true, isUp: true, pingsInState: 2},
+		"192.0.2.2": {stableIsUp: true, isUp: false, pingsInState: 1},
+		"192.0.2.3": {},
+	} {
+		ri := restored.send[ip]
+		if ri.stableIsUp != want.stableIsUp || ri.isUp != want.isUp || ri.pingsInState != want.pingsInState {
+			t.Errorf("%s: got up %v replying %v in state %d", ip, ri.stableIsUp, ri.isUp, ri.pingsInState)
+		}
+	}
+
+	g := restored.groups[0]
+	if g.totalAlive != 2 || !g.wasAlive || !g.assessed || g.firedState != stateAlive {
+		t.Errorf("got group up %d, was alive %v, assessed %v, fired %q", g.totalAlive, g.wasAlive, g.assessed, g.firedState)
+	}
+	// the new host is not up yet, but the group stays alive above group dead without firing again
+	if !g.isTotalAlive || !g.lastCommand.IsZero() {
+		t.Errorf("group alive %v, command fired at %s", g.isTotalAlive, g.lastCommand)
+	}
+}
+
+func TestStateFileMissingOrCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	configure := func(p *Ping) { p.stateFile = path }
+
+	p, _ := newTestPing(t, configure, "192.0.2.1")
+	if p.send["192.0.2.1"].stableIsUp {
+		t.Error("host is up without a saved state")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = newTestPing(t, configure, "192.0.2.1")
+	if p.send["192.0.2.1"].stableIsUp || p.groups[0].assessed {
+		t.Error("state restored from a corrupt file")
+	}
+}
@@ -0,0 +1,125 @@
+package src
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// timestampSize is the size of the send timestamp embedded in the echo data
+const timestampSize = 8
+
+// rttStats is a running summary of the round-trip times of a host
+type rttStats struct {
+	last  time.Duration
+	min   time.Duration
+	max   time.Duration
+	sum   time.Duration
+	count int
+
+	squares float64 // sum of the squared rtt in ns², for the deviation
+}
+
+func (s *rttStats) add(rtt time.Duration) {
+	if s.count == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.last = rtt
+	s.sum += rtt
+	s.squares += float64(rtt) * float64(rtt)
+	s.count++
+}
+
+func (s *rttStats) avg() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / time.Duration(s.count)
+}
+
+// jitter returns the standard deviation of the rtt
+func (s *rttStats) jitter() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	mean := float64(s.sum) / float64(s.count)
+	return time.Duration(math.Sqrt(max(0, s.squares/float64(s.count)-mean*mean)))
+}
+
+// loss returns the percentage of the sent pings left without a reply
+func (ri *remoteInfo) loss() float64 {
+	if ri.sent == 0 {
+		return 0
+	}
+	return 100 * float64(ri.sent-ri.received) / float64(ri.sent)
+}
+
+// printSummary writes the per-host packet loss and rtt table
+func (p *Ping) printSummary(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hosts := make([]*remoteInfo, 0, len(p.send))
+	for _, ri := range p.send {
+		hosts = append(hosts, ri)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].address() < hosts[j].address() })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "HOST\tSENT\tRECV\tLOSS\tMIN\tAVG\tMAX\tJITTER\t")
+	for _, ri := range hosts {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%s\t%s\t%s\t%s\t\n",
+			ri, ri.sent, ri.received, ri.loss(),
+			ri.rtt.min, ri.rtt.avg(), ri.rtt.max, ri.rtt.jitter())
+	}
+	_ = tw.Flush()
+}
+
+// logRoundSummary logs the number of the alive hosts and the state of the groups
+// at the end of the round, e.g. round 12: 7/10 up, group alive
+func (p *Ping) logRoundSummary(round int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	up := 0
+	for _, ri := range p.send {
+		if ri.stableIsUp {
+			up++
+		}
+	}
+
+	fields := []zap.Field{zap.Int("round", round), zap.String("up", fmt.Sprintf("%d/%d", up, len(p.send)))}
+	for _, g := range p.groups {
+		state := stateDead
+		if g.isTotalAlive {
+			state = stateAlive
+		}
+		key := "group"
+		if g.name != "" {
+			key = "group " + g.name
+		}
+		fields = append(fields, zap.String(key, state))
+	}
+	p.log.Info("Round summary", fields...)
+}
+
+// encodeTimestamp stores the send time into the echo data
+func encodeTimestamp(data []byte, ts time.Duration) {
+	binary.BigEndian.PutUint64(data, uint64(ts))
+}
+
+// decodeTimestamp extracts the send time from the echo data
+func decodeTimestamp(data []byte) (time.Duration, bool) {
+	if len(data) < timestampSize {
+		return 0, false
+	}
+	return time.Duration(binary.BigEndian.Uint64(data)), true
+}
@@ -0,0 +1,108 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStatsdPacket keeps the datagrams within the common MTU
+const maxStatsdPacket = 1432
+
+// statsd emits the round results to a StatsD agent over UDP with the DogStatsD tags,
+// fire and forget, all the methods are no-ops on a nil receiver
+type statsd struct {
+	log  *zap.Logger
+	conn net.Conn
+}
+
+func newStatsd(log *zap.Logger, addr string) *statsd {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Error("Failed to open the StatsD socket", zap.String("addr", addr), zap.Error(err))
+		return nil
+	}
+	return &statsd{log: log, conn: conn}
+}
+
+// writeRound emits the host and group gauges and the rtt timers of the hosts
+// which replied in the round, packing several metrics into a datagram
+func (s *statsd) writeRound(hosts map[string]*remoteInfo, groups []*group) {
+	if s == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(hosts))
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		ri := hosts[key]
+		tags := statsdTags("ip", key, "label", ri.label, "group", ri.group.name)
+		lines = append(lines, fmt.Sprintf("pinger.up:%d|g%s", boolInt(ri.stableIsUp), tags))
+		if ri.gotReply && ri.rtt.count > 0 {
+			rtt := strconv.FormatFloat(float64(ri.rtt.last)/float64(time.Millisecond), 'f', -1, 64)
+			lines = append(lines, "pinger.rtt:"+rtt+"|ms"+tags)
+		}
+	}
+
+	for _, g := range groups {
+		tags := statsdTags("group", g.name)
+		lines = append(lines,
+			fmt.Sprintf("pinger.group.alive:%d|g%s", boolInt(g.isTotalAlive), tags),
+			fmt.Sprintf("pinger.group.up_count:%d|g%s", g.totalAlive, tags))
+	}
+
+	var b bytes.Buffer
+	for _, line := range lines {
+		if b.Len() > 0 && b.Len()+1+len(line) > maxStatsdPacket {
+			s.send(b.Bytes())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		s.send(b.Bytes())
+	}
+}
+
+func (s *statsd) send(packet []byte) {
+	if _, err := s.conn.Write(packet); err != nil {
+		s.log.Debug("Failed to send to StatsD", zap.Error(err))
+	}
+}
+
+func (s *statsd) close() {
+	if s == nil {
+		return
+	}
+	_ = s.conn.Close()
+}
+
+// statsdTagEscaper replaces the characters separating the tags and the metric fields
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// statsdTags renders the key value pairs as the DogStatsD tags, skipping the empty values
+func statsdTags(kv ...string) string {
+	var tags []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			tags = append(tags, kv[i]+":"+statsdTagEscaper.Replace(kv[i+1]))
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
@@ -0,0 +1,43 @@
+package src
+
+import (
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdWriteRound(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = agent.Close() }()
+
+	s := newStatsd(zap.NewNop(), agent.LocalAddr().String())
+	defer s.close()
+
+	g := &group{name: "uplinks", size: 1, totalAlive: 1, isTotalAlive: true}
+	ri := &remoteInfo{label: "core|router", group: g, stableIsUp: true, gotReply: true}
+	ri.rtt.add(1500 * time.Microsecond)
+	s.writeRound(map[string]*remoteInfo{"192.0.2.1": ri}, []*group{g})
+
+	buf := make([]byte, maxStatsdPacket)
+	_ = agent.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := agent.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(string(buf[:n]), "\n")
+	want := []string{
+		"pinger.up:1|g|#ip:192.0.2.1,label:core_router,group:uplinks",
+		"pinger.rtt:1.5|ms|#ip:192.0.2.1,label:core_router,group:uplinks",
+		"pinger.group.alive:1|g|#group:uplinks",
+		"pinger.group.up_count:1|g|#group:uplinks",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,121 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type hostStatus struct {
+	IP           string  `json:"ip"`
+	Name         string  `json:"name,omitempty"`
+	Label        string  `json:"label,omitempty"`
+	Group        string  `json:"group,omitempty"`
+	Up           bool    `json:"up"`       // the confirmed state
+	Replying     bool    `json:"replying"` // the state of the last pings
+	Degraded     bool    `json:"degraded,omitempty"`
+	Unknown      bool    `json:"unknown,omitempty"` // neither alive nor dead yet
+	PingsInState int     `json:"pings_in_state"`
+	LastChange   string  `json:"last_change"` // when the confirmed state began
+	InState      float64 `json:"in_state_s"`  // seconds since then
+	LastRTT      float64 `json:"last_rtt_ms"`
+}
+
+type groupStatus struct {
+	Name    string `json:"name,omitempty"`
+	Alive   bool   `json:"alive"`
+	UpCount int    `json:"up_count"`
+	Hosts   int    `json:"hosts"`
+}
+
+type status struct {
+	Alive  bool          `json:"alive"`
+	Paused bool          `json:"paused,omitempty"`
+	Groups []groupStatus `json:"groups"`
+	Hosts  []hostStatus  `json:"hosts"`
+}
+
+// snapshot returns the current state of the hosts and the groups
+func (p *Ping) snapshot() status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := status{Alive: true, Paused: p.paused}
+	for _, g := range p.groups {
+		s.Alive = s.Alive && g.isTotalAlive
+		s.Groups = append(s.Groups, groupStatus{
+			Name:    g.name,
+			Alive:   g.isTotalAlive,
+			UpCount: g.totalAlive,
+			Hosts:   g.size,
+		})
+	}
+
+	for _, ri := range p.send {
+		s.Hosts = append(s.Hosts, hostStatus{
+			IP:           ri.address(),
+			Name:         ri.name,
+			Label:        ri.label,
+			Group:        ri.group.name,
+			Up:           ri.stableIsUp,
+			Replying:     ri.isUp,
+			Degraded:     ri.degraded,
+			Unknown:      ri.unknown,
+			PingsInState: ri.pingsInState,
+			LastChange:   ri.lastChange.Format(time.RFC3339),
+			InState:      time.Since(ri.lastChange).Seconds(),
+			LastRTT:      float64(ri.rtt.last) / float64(time.Millisecond),
+		})
+	}
+	sort.Slice(s.Hosts, func(i, j int) bool { return s.Hosts[i].IP < s.Hosts[j].IP })
+
+	return s
+}
+
+// statusServer serves the current state as JSON
+type statusServer struct {
+	log    *zap.Logger
+	server *http.Server
+}
+
+func newStatusServer(p *Ping, addr string) *statusServer {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(p.snapshot()); err != nil {
+			p.log.Error("Failed to write status", zap.Error(err))
+		}
+	})
+
+	return &statusServer{
+		log:    p.log,
+		server: &http.Server{Addr: addr, Handler: mux},
+	}
+}
+
+func (s *statusServer) start() {
+	if s == nil {
+		return
+	}
+
+	go func() {
+		s.log.Info("Serving status", zap.String("addr", s.server.Addr))
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("Status server failed", zap.Error(err))
+		}
+	}()
+}
+
+func (s *statusServer) close() {
+	if s == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = s.server.Shutdown(ctx)
+}
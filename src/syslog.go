@@ -0,0 +1,79 @@
+//go:build !windows && !plan9
+
+package src
+
+import (
+	"go.uber.org/zap/zapcore"
+	"log/syslog"
+	"strings"
+)
+
+const syslogTag = "net-pinger"
+
+// syslogCore writes the log entries to syslog with the severity matching their level
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc    zapcore.Encoder
+	writer *syslog.Writer
+}
+
+// newSyslogCore connects to the syslog daemon at the address, network://host:port
+// with udp being the default network, or to the local one if the address is empty
+func newSyslogCore(addr string, enc zapcore.Encoder, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+		if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+			network, addr = scheme, rest
+		}
+	}
+
+	writer, err := syslog.Dial(network, addr, syslog.LOG_DAEMON|syslog.LOG_INFO, syslogTag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogCore{LevelEnabler: level, enc: enc, writer: writer}, nil
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *syslogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	msg := strings.TrimSuffix(buf.String(), "\n")
+	switch {
+	case e.Level >= zapcore.DPanicLevel:
+		return c.writer.Crit(msg)
+	case e.Level >= zapcore.ErrorLevel:
+		return c.writer.Err(msg)
+	case e.Level >= zapcore.WarnLevel:
+		return c.writer.Warning(msg)
+	case e.Level >= zapcore.InfoLevel:
+		return c.writer.Info(msg)
+	default:
+		return c.writer.Debug(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package src
+
+import (
+	"errors"
+	"go.uber.org/zap/zapcore"
+)
+
+func newSyslogCore(string, zapcore.Encoder, zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,318 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCIDRHosts limits the number of hosts a single CIDR block may expand to
+const maxCIDRHosts = 65536
+
+// target is a single address to ping, optionally backed by a hostname
+type target struct {
+	ip    net.IP
+	zone  string // IPv6 scope of the link-local addresses, e.g. fe80::1%eth0
+	name  string
+	label string // friendly name given by the user, e.g. 192.0.2.1=core-router
+	opts  targetOptions
+}
+
+// targetOptions are the per-host overrides given after the address,
+// e.g. 192.0.2.1:alive=5,dead=8,weight=3,pause=1m
+type targetOptions struct {
+	aliveCount uint8 // number of alive pings to consider host alive, 0 for default
+	deadCount  uint8 // number of dead pings to consider host dead, 0 for default
+	weight     uint8 // weight of the host in the group thresholds, 0 for default 1
+
+	pause time.Duration // delay between the pings of the host, 0 for default
+}
+
+// parseTarget converts a command line argument into the list of targets,
+// resolving hostnames into all of their addresses
+func parseTarget(arg string) ([]target, error) {
+	addr, opts, err := splitTargetOptions(arg)
+	if err != nil {
+		return nil, err
+	}
+	addr, label := splitLabel(addr)
+
+	targets, err := parseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		targets[i].label = label
+		targets[i].opts = opts
+	}
+
+	return targets, nil
+}
+
+// splitTargetList splits the comma-separated list of targets, keeping the commas
+// of the per-host options, e.g. 192.0.2.1:alive=5,dead=8,db.internal
+func splitTargetList(list string) []string {
+	var args []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case len(args) > 0 && strings.Contains(args[len(args)-1], ":") && isTargetOptions(item):
+			args[len(args)-1] += "," + item
+		default:
+			args = append(args, item)
+		}
+	}
+	return args
+}
+
+// splitTargetOptions separates the per-host options suffix from the address,
+// the suffix is recognized by its known keys so IPv6 colons do not confuse it,
+// the address may also be bracketed, e.g. [2001:db8::1]:dead=3
+func splitTargetOptions(arg string) (string, targetOptions, error) {
+	var opts targetOptions
+
+	i := strings.LastIndexByte(arg, ':')
+	if i < 0 || !isTargetOptions(arg[i+1:]) {
+		return unbracket(arg), opts, nil
+	}
+
+	for _, kv := range strings.Split(arg[i+1:], ",") {
+		key, value, _ := strings.Cut(kv, "=")
+		if key == "pause" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return "", opts, fmt.Errorf("invalid pause option of %s: must be a positive duration", arg)
+			}
+			opts.pause = d
+			continue
+		}
+
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return "", opts, fmt.Errorf("invalid %s option of %s: %w", key, arg, err)
+		}
+		if n == 0 {
+			return "", opts, fmt.Errorf("invalid %s option of %s: must be positive", key, arg)
+		}
+
+		switch key {
+		case "alive":
+			opts.aliveCount = uint8(n)
+		case "dead":
+			opts.deadCount = uint8(n)
+		case "weight":
+			opts.weight = uint8(n)
+		}
+	}
+
+	return unbracket(arg[:i]), opts, nil
+}
+
+// splitLabel separates the label from the address, e.g. 192.0.2.1=core-router
+func splitLabel(addr string) (string, string) {
+	addr, label, _ := strings.Cut(addr, "=")
+	return unbracket(addr), label
+}
+
+// unbracket strips the brackets around an IPv6 address
+func unbracket(addr string) string {
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		return addr[1 : len(addr)-1]
+	}
+	return addr
+}
+
+// isTargetOptions checks whether s is a list of known key=value options
+func isTargetOptions(s string) bool {
+	for _, kv := range strings.Split(s, ",") {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok {
+			return false
+		}
+
+		switch key {
+		case "alive", "dead", "weight", "pause":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// address returns the ip with the zone, if any, e.g. fe80::1%eth0, the key of the host
+func (t target) address() string {
+	return zonedAddress(t.ip, t.zone)
+}
+
+// zonedAddress returns the ip with the zone, if any, as the hosts are told apart
+func zonedAddress(ip net.IP, zone string) string {
+	if zone != "" {
+		return ip.String() + "%" + zone
+	}
+	return ip.String()
+}
+
+// parseAddress resolves an address, a hostname or a CIDR block into the targets
+func parseAddress(arg string) ([]target, error) {
+	if strings.Contains(arg, "/") {
+		return parseCIDR(arg)
+	}
+
+	if addr, zone, ok := strings.Cut(arg, "%"); ok {
+		return parseZoned(addr, zone)
+	}
+
+	if ip := net.ParseIP(arg); ip != nil {
+		return []target{{ip: ip}}, nil
+	}
+
+	ips, err := net.LookupIP(arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", arg, err)
+	}
+
+	targets := make([]target, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, target{ip: ip, name: arg})
+	}
+
+	return targets, nil
+}
+
+// parseZoned parses the IPv6 address with the zone, the name or the index of an interface.
+// The index of a known interface is replaced with its name, which the replies carry.
+func parseZoned(addr, zone string) ([]target, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("zone of %s%%%s requires an IPv6 address", addr, zone)
+	}
+
+	if index, err := strconv.Atoi(zone); err != nil {
+		if _, err = net.InterfaceByName(zone); err != nil {
+			return nil, fmt.Errorf("invalid zone of %s%%%s: %w", addr, zone, err)
+		}
+	} else if iface, err := net.InterfaceByIndex(index); err == nil {
+		zone = iface.Name
+	}
+
+	return []target{{ip: ip, zone: zone}}, nil
+}
+
+// parseCIDR enumerates the usable host addresses of the network
+func parseCIDR(arg string) ([]target, error) {
+	_, network, err := net.ParseCIDR(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("network %s is too large, at most %d hosts are allowed", arg, maxCIDRHosts)
+	}
+
+	var targets []target
+	for ip := network.IP; network.Contains(ip); ip = nextIP(ip) {
+		targets = append(targets, target{ip: ip})
+	}
+
+	// the network and broadcast addresses are not hosts, except for the
+	// point-to-point /31 and single host /32 (and their IPv6 counterparts)
+	if bits-ones > 1 {
+		targets = targets[1:]
+		if bits == 8*net.IPv4len {
+			targets = targets[:len(targets)-1]
+		}
+	}
+
+	return targets, nil
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// isBroadcast returns whether the ip is a multicast, the limited broadcast or one of the
+// local broadcast addresses, the replies to which come from the other addresses if at all
+func isBroadcast(ip net.IP, local []net.IP) bool {
+	if ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	for _, b := range local {
+		if ip.Equal(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// localBroadcasts returns the broadcast addresses of the networks of the local interfaces
+func localBroadcasts() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var broadcasts []net.IP
+	for _, addr := range addrs {
+		network, ok := addr.(*net.IPNet)
+		if !ok || network.IP.To4() == nil {
+			continue
+		}
+		if ones, bits := network.Mask.Size(); bits-ones < 2 {
+			continue
+		}
+		ip, mask := network.IP.To4(), network.Mask[len(network.Mask)-net.IPv4len:]
+		b := make(net.IP, net.IPv4len)
+		for i := range b {
+			b[i] = ip[i] | ^mask[i]
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts
+}
+
+// readTargetsFile loads targets from a file listing one per line,
+// blank lines and # comments are ignored
+func readTargetsFile(path string) ([]target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var targets []target
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		t, err := parseTarget(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		targets = append(targets, t...)
+	}
+
+	return targets, scanner.Err()
+}
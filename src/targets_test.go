@@ -0,0 +1,260 @@
+package src
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSplitTargetOptions(t *testing.T) {
+	tests := []struct {
+		arg      string
+		wantAddr string
+		wantOpts targetOptions
+		wantErr  bool
+	}{
+		{arg: "192.0.2.1", wantAddr: "192.0.2.1"},
+		{arg: "example.com", wantAddr: "example.com"},
+		{arg: "192.0.2.1:alive=5", wantAddr: "192.0.2.1", wantOpts: targetOptions{aliveCount: 5}},
+		{arg: "192.0.2.1:alive=5,dead=8", wantAddr: "192.0.2.1", wantOpts: targetOptions{aliveCount: 5, deadCount: 8}},
+		{arg: "192.0.2.1:weight=3,dead=8", wantAddr: "192.0.2.1", wantOpts: targetOptions{deadCount: 8, weight: 3}},
+		{arg: "192.0.2.0/30:dead=2", wantAddr: "192.0.2.0/30", wantOpts: targetOptions{deadCount: 2}},
+		{arg: "2001:db8::1", wantAddr: "2001:db8::1"},
+		{arg: "2001:db8::1:dead=3", wantAddr: "2001:db8::1", wantOpts: targetOptions{deadCount: 3}},
+		{arg: "[2001:db8::1]", wantAddr: "2001:db8::1"},
+		{arg: "[2001:db8::1]:dead=3", wantAddr: "2001:db8::1", wantOpts: targetOptions{deadCount: 3}},
+		{arg: "192.0.2.1:alive=0", wantErr: true},
+		{arg: "192.0.2.1:dead=0", wantErr: true},
+		{arg: "192.0.2.1:alive=256", wantErr: true},
+		{arg: "192.0.2.1:weight=0", wantErr: true},
+		{arg: "192.0.2.1:alive=x", wantErr: true},
+		{arg: "192.0.2.1:pause=1m,dead=2", wantAddr: "192.0.2.1", wantOpts: targetOptions{deadCount: 2, pause: time.Minute}},
+		{arg: "192.0.2.1:pause=0s", wantErr: true},
+		{arg: "192.0.2.1:pause=5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			addr, opts, err := splitTargetOptions(tt.arg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, got %s %+v", addr, opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != tt.wantAddr || opts != tt.wantOpts {
+				t.Errorf("got %s %+v, want %s %+v", addr, opts, tt.wantAddr, tt.wantOpts)
+			}
+		})
+	}
+}
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    []string
+		wantErr bool
+	}{
+		{arg: "192.0.2.0/30", want: []string{"192.0.2.1", "192.0.2.2"}},
+		{arg: "192.0.2.5/30", want: []string{"192.0.2.5", "192.0.2.6"}},
+		{arg: "192.0.2.0/31", want: []string{"192.0.2.0", "192.0.2.1"}},
+		{arg: "192.0.2.7/32", want: []string{"192.0.2.7"}},
+		{arg: "2001:db8::/126", want: []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{arg: "2001:db8::/127", want: []string{"2001:db8::", "2001:db8::1"}},
+		{arg: "10.0.0.0/15", wantErr: true},
+		{arg: "192.0.2.0/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			targets, err := parseCIDR(tt.arg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, got %d targets", len(targets))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, target := range targets {
+				got = append(got, target.ip.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDRSize(t *testing.T) {
+	targets, err := parseCIDR("10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != maxCIDRHosts-2 {
+		t.Errorf("got %d targets, want %d", len(targets), maxCIDRHosts-2)
+	}
+}
+
+func TestReadTargetsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	content := "# uplinks\n192.0.2.1\n\n  192.0.2.2:alive=5  \n192.0.2.0/31\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := readTargetsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, target := range targets {
+		got = append(got, target.ip.String())
+	}
+	want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.0", "192.0.2.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if targets[1].opts.aliveCount != 5 {
+		t.Errorf("alive count = %d, want 5", targets[1].opts.aliveCount)
+	}
+}
+
+func TestParseTargetLabel(t *testing.T) {
+	tests := []struct {
+		arg       string
+		wantIP    string
+		wantLabel string
+		wantOpts  targetOptions
+	}{
+		{arg: "192.0.2.1", wantIP: "192.0.2.1"},
+		{arg: "192.0.2.1=core-router", wantIP: "192.0.2.1", wantLabel: "core-router"},
+		{arg: "192.0.2.1=core-router:alive=5", wantIP: "192.0.2.1", wantLabel: "core-router", wantOpts: targetOptions{aliveCount: 5}},
+		{arg: "2001:db8::1=uplink", wantIP: "2001:db8::1", wantLabel: "uplink"},
+		{arg: "[2001:db8::1]=uplink:dead=3", wantIP: "2001:db8::1", wantLabel: "uplink", wantOpts: targetOptions{deadCount: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			targets, err := parseTarget(tt.arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(targets) != 1 {
+				t.Fatalf("got %d targets, want 1", len(targets))
+			}
+
+			got := targets[0]
+			if got.ip.String() != tt.wantIP || got.label != tt.wantLabel || got.opts != tt.wantOpts {
+				t.Errorf("got %s %q %+v, want %s %q %+v", got.ip, got.label, got.opts, tt.wantIP, tt.wantLabel, tt.wantOpts)
+			}
+		})
+	}
+}
+
+func TestParseTargetZone(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no network interfaces")
+	}
+	name, index := ifaces[0].Name, strconv.Itoa(ifaces[0].Index)
+
+	tests := []struct {
+		arg      string
+		wantIP   string
+		wantZone string
+		wantErr  bool
+	}{
+		{arg: "fe80::1%" + name, wantIP: "fe80::1", wantZone: name},
+		{arg: "fe80::1%" + index, wantIP: "fe80::1", wantZone: name},
+		{arg: "fe80::1%65535", wantIP: "fe80::1", wantZone: "65535"},
+		{arg: "[fe80::1%" + name + "]=gateway:dead=3", wantIP: "fe80::1", wantZone: name},
+		{arg: "fe80::1%no-such-interface", wantErr: true},
+		{arg: "192.0.2.1%1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			targets, err := parseTarget(tt.arg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, got %+v", targets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := targets[0]
+			if got.ip.String() != tt.wantIP || got.zone != tt.wantZone {
+				t.Errorf("got %s zone %q, want %s zone %q", got.ip, got.zone, tt.wantIP, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestBroadcastTargets(t *testing.T) {
+	local := []net.IP{net.ParseIP("192.0.2.255")}
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.255", true},
+		{"255.255.255.255", true},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"192.0.2.1", false},
+		{"198.51.100.255", false},
+	} {
+		if got := isBroadcast(net.ParseIP(tt.ip), local); got != tt.want {
+			t.Errorf("%s: broadcast %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	targets := func(ips ...string) []target {
+		var list []target
+		for _, ip := range ips {
+			list = append(list, target{ip: net.ParseIP(ip)})
+		}
+		return list
+	}
+	if err := (&Ping{}).checkBroadcasts(targets("192.0.2.1", "224.0.0.1")); err == nil {
+		t.Error("accepted a multicast host without allowing it")
+	}
+	p := &Ping{allowBroadcast: true}
+	if err := p.checkBroadcasts(targets("192.0.2.1", "224.0.0.1", "ff02::1")); err != nil {
+		t.Error(err)
+	}
+	if err := p.checkBroadcasts(targets("255.255.255.255", "224.0.0.1")); err == nil {
+		t.Error("accepted two broadcast hosts of a family")
+	}
+}
+
+func TestSplitTargetList(t *testing.T) {
+	for _, tt := range []struct {
+		list string
+		want []string
+	}{
+		{"192.0.2.1,192.0.2.2,db.internal", []string{"192.0.2.1", "192.0.2.2", "db.internal"}},
+		{" 192.0.2.0/28 , ,2001:db8::1 ", []string{"192.0.2.0/28", "2001:db8::1"}},
+		{"192.0.2.1:alive=5,dead=8,192.0.2.2=core", []string{"192.0.2.1:alive=5,dead=8", "192.0.2.2=core"}},
+		{"[2001:db8::1]:weight=2,192.0.2.3", []string{"[2001:db8::1]:weight=2", "192.0.2.3"}},
+		{"", nil},
+	} {
+		if got := splitTargetList(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	webhookTimeout  = 5 * time.Second
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// notification is a webhook request prepared for the backend selected by the url scheme
+type notification struct {
+	endpoint string // the url to post to
+	name     string // the backend name, or the url itself for the plain webhooks
+	body     []byte
+}
+
+// newNotification prepares the event for the url:
+//
+//	slack://hooks.slack.com/services/...  posts a message to the slack incoming webhook
+//	telegram://<bot token>/<chat id>      sends a message to the chat by the bot
+//	http(s)://...                         posts the event as JSON
+func newNotification(url string, e event) (notification, error) {
+	if rest, ok := strings.CutPrefix(url, "slack://"); ok {
+		body, err := json.Marshal(map[string]string{"text": e.message()})
+		return notification{"https://" + rest, "slack", body}, err
+	}
+
+	if rest, ok := strings.CutPrefix(url, "telegram://"); ok {
+		token, chat, ok := strings.Cut(rest, "/")
+		if !ok || token == "" || chat == "" {
+			return notification{}, errors.New("telegram url must be telegram://<bot token>/<chat id>")
+		}
+		body, err := json.Marshal(map[string]string{"chat_id": chat, "text": e.message()})
+		return notification{"https://api.telegram.org/bot" + token + "/sendMessage", "telegram", body}, err
+	}
+
+	body, err := json.Marshal(e)
+	return notification{url, url, body}, err
+}
+
+// checkWebhook rejects the webhook url of an unknown scheme or missing its parts,
+// the error leaves out the url as it may carry a token
+func checkWebhook(webhook string) error {
+	n, err := newNotification(webhook, event{})
+	if err != nil {
+		return err
+	}
+	if u, err := url.Parse(n.endpoint); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errors.New("webhook url must be http, https, slack or telegram")
+	}
+	return nil
+}
+
+// message returns the event as a human-readable chat message
+func (e event) message() string {
+	group := "Network"
+	if e.Group != "" {
+		group = "Group " + e.Group
+	}
+	return fmt.Sprintf("%s is %s: %d hosts up after %s changed at %s",
+		group, e.State, e.UpCount, e.IP, e.Time.Format(time.RFC3339))
+}
+
+// postWebhook posts the event in the background, retrying on failures
+func (p *Ping) postWebhook(url string, e event) {
+	if url == "" {
+		return
+	}
+
+	n, err := newNotification(url, e)
+	if err != nil {
+		p.log.Error("Failed to prepare webhook", zap.Error(err))
+		return
+	}
+
+	p.commands.Add(1)
+	go func() {
+		defer p.commands.Done()
+
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			if err = postJSON(n.endpoint, n.body); err == nil {
+				p.log.Debug("Webhook posted", zap.String("url", n.name))
+				return
+			}
+
+			p.log.Warn("Failed to post webhook",
+				zap.String("url", n.name),
+				zap.Int("attempt", attempt),
+				zap.Error(err))
+			if attempt < webhookAttempts {
+				time.Sleep(webhookBackoff * time.Duration(attempt))
+			}
+		}
+	}()
+}
+
+func postJSON(url string, body []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error quotes the url, which carries the token of the bot
+		if cause := errors.Unwrap(err); cause != nil {
+			return cause
+		}
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
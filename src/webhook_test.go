@@ -0,0 +1,23 @@
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostJSONHidesToken(t *testing.T) {
+	n, err := newNotification("telegram://123:secret/42", event{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing listens on the port, so the post fails
+	n.endpoint = strings.Replace(n.endpoint, "api.telegram.org", "127.0.0.1:1", 1)
+	err = postJSON(n.endpoint, n.body)
+	if err == nil {
+		t.Fatal("no error posting to a closed port")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q reveals the token", err)
+	}
+}
@@ -0,0 +1,52 @@
+package src
+
+// resultWindow keeps the results of the last pings of a host for the sliding window mode,
+// where the alive and dead counts are taken from them instead of the consecutive ones
+type resultWindow struct {
+	results []bool // ring of the last results, true for a reply
+	next    int    // index the next result is written at
+	filled  int    // number of the results written, up to the size
+	replies int    // number of the replies among them
+}
+
+// newResultWindow returns the window of the size, nil for 0 to count the consecutive pings
+func newResultWindow(size int) *resultWindow {
+	if size == 0 {
+		return nil
+	}
+	return &resultWindow{results: make([]bool, size)}
+}
+
+// add records the result, pushing out the oldest one once full
+func (w *resultWindow) add(reply bool) {
+	if w.filled == len(w.results) {
+		if w.results[w.next] {
+			w.replies--
+		}
+	} else {
+		w.filled++
+	}
+
+	w.results[w.next] = reply
+	if reply {
+		w.replies++
+	}
+	w.next = (w.next + 1) % len(w.results)
+}
+
+// count returns the number of the replies in the window, or of the timeouts if not reply
+func (w *resultWindow) count(reply bool) int {
+	if reply {
+		return w.replies
+	}
+	return w.filled - w.replies
+}
+
+// reset forgets the results, e.g. when the address of the host changes
+func (w *resultWindow) reset() {
+	if w == nil {
+		return
+	}
+	clear(w.results)
+	w.next, w.filled, w.replies = 0, 0, 0
+}
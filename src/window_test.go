@@ -0,0 +1,32 @@
+package src
+
+import "testing"
+
+func TestResultWindow(t *testing.T) {
+	if newResultWindow(0) != nil {
+		t.Error("window of size 0 is not nil")
+	}
+
+	w := newResultWindow(3)
+	for i, tt := range []struct {
+		reply             bool
+		replies, timeouts int
+	}{
+		{true, 1, 0},
+		{false, 1, 1},
+		{true, 2, 1},
+		{true, 2, 1}, // the first reply is pushed out
+		{false, 2, 1},
+		{false, 1, 2},
+	} {
+		w.add(tt.reply)
+		if w.count(true) != tt.replies || w.count(false) != tt.timeouts {
+			t.Errorf("after %d: %d replies and %d timeouts, want %d and %d", i+1, w.count(true), w.count(false), tt.replies, tt.timeouts)
+		}
+	}
+
+	w.reset()
+	if w.count(true) != 0 || w.count(false) != 0 {
+		t.Error("the reset window is not empty")
+	}
+}